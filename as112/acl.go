@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// prefixes is the list of networks given with -allow, it can be repeated.
+type prefixes []*net.IPNet
+
+func (p *prefixes) String() string {
+	s := make([]string, len(*p))
+	for i := range *p {
+		s[i] = (*p)[i].String()
+	}
+	return strings.Join(s, ",")
+}
+
+func (p *prefixes) Set(s string) error {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return fmt.Errorf("invalid prefix: %q", s)
+	}
+	*p = append(*p, n)
+	return nil
+}
+
+func (p prefixes) contains(ip net.IP) bool {
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed wraps h so that clients not in -allow are refused. Loopback clients
+// and queries from the address they are sent to are always allowed: the health
+// checks query each listener from the node itself, for a listener on an
+// anycast address the source is that address.
+func allowed(h dns.Handler) dns.Handler {
+	if len(allow) == 0 {
+		return h
+	}
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		ip := addrIP(w.RemoteAddr())
+		if ip.IsLoopback() || ip.Equal(addrIP(w.LocalAddr())) || allow.contains(ip) {
+			h.ServeDNS(w, r)
+			return
+		}
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+	})
+}
+
+func addrIP(a net.Addr) net.IP {
+	switch a := a.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	return nil
+}
@@ -4,54 +4,176 @@
 
 // An AS112 blackhole DNS server. Similar to the one found in evldns.
 // Also see https://www.as112.net/
+//
+// The zones of RFC 7534 are served with an SOA and NS records at the apex, the
+// name servers are blackhole-1.iana.org and blackhole-2.iana.org unless -ns is
+// given. The hostname.as112.net zone tells who operates the node, its TXT and
+// LOC records are set with -facility, -city, -ip and -loc.
+//
+// For AS112 redirection (RFC 7535) the empty.as112.arpa zone, the target of
+// the DNAME records, and hostname.as112.arpa are served as well.
+//
+// With -ratelimit the UDP responses are rate limited per /24 (IPv4) or /56
+// (IPv6), see -ipv4-prefix and -ipv6-prefix, every -slip'th limited response
+// is sent truncated and every -leak'th is sent as is. At most -ratelimit-size
+// prefixes are tracked.
+//
+// The zones can be served over DNS over TLS and HTTPS as well, with -tls-port
+// and -https-port, the certificate is given with -cert and -key.
+//
+// Without -listen the server listens on all addresses on -port, an AS112 node
+// normally listens on the anycast addresses only (192.175.48.{1,6,42} and
+// 192.31.196.1 and their IPv6 counterparts), these are given with -listen
+// IP:port. The DNS over TLS and HTTPS listeners use the same addresses. With
+// -interface the sockets are bound to an interface.
+//
+// With -health a /healthz HTTP endpoint reports the uptime and queries each
+// listener, it returns 503 when one of them doesn't answer.
+//
+// With -allow only the clients in the given prefixes (and the node itself) are
+// answered, the others are refused, so a node inside a network can't be used
+// as a reflector from the outside.
+//
+// With -minimal-responses the authority section of positive answers is left
+// empty and ANY gets one RRset, -compress compresses the replies.
+//
+// Under systemd (Type=notify) readiness is signaled and, when WatchdogSec is
+// set, the watchdog is kicked. As root the listeners are set up first, then
+// -user and -group are switched to.
+//
+// The SOA serial is made from the date (YYYYMMDDnn) unless -serial is given.
+// On SIGHUP the zones are created again with a higher serial and the file
+// given with -zones is read again, on SIGINT and SIGTERM the queries in flight
+// are answered before stopping.
 
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime/pprof"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/miekg/dns"
+	"github.com/miekg/exdns/ratelimit"
 )
 
-// SOA is a string we will append everywhere in the zones values.
-const SOA string = "@ SOA prisoner.iana.org. hostmaster.root-servers.org. 2002040800 1800 900 0604800 604800"
+// SOA is a string we will append everywhere in the zones values. The serial is
+// set when the zones are created, see load.
+const SOA string = "@ 604800 SOA prisoner.iana.org. hostmaster.root-servers.org. 0 1800 900 0604800 604800"
+
+// SOA7535 is the SOA of the zones used for AS112 redirection, RFC 7535.
+const SOA7535 string = "@ 604800 SOA blackhole.as112.arpa. noc.dns.icann.org. 0 604800 60 604800 604800"
 
 // NewRR is a shortcut to dns.NewRR that ignores the error.
 func NewRR(s string) dns.RR { r, _ := dns.NewRR(s); return r }
 
-var zones = map[string]dns.RR{
-	"10.in-addr.arpa.":      NewRR("$ORIGIN 10.in-addr.arpa.\n" + SOA),
-	"254.169.in-addr.arpa.": NewRR("$ORIGIN 254.169.in-addr.arpa.\n" + SOA),
-	"168.192.in-addr.arpa.": NewRR("$ORIGIN 168.192.in-addr.arpa.\n" + SOA),
-	"16.172.in-addr.arpa.":  NewRR("$ORIGIN 16.172.in-addr.arpa.\n" + SOA),
-	"17.172.in-addr.arpa.":  NewRR("$ORIGIN 17.172.in-addr.arpa.\n" + SOA),
-	"18.172.in-addr.arpa.":  NewRR("$ORIGIN 18.172.in-addr.arpa.\n" + SOA),
-	"19.172.in-addr.arpa.":  NewRR("$ORIGIN 19.172.in-addr.arpa.\n" + SOA),
-	"20.172.in-addr.arpa.":  NewRR("$ORIGIN 20.172.in-addr.arpa.\n" + SOA),
-	"21.172.in-addr.arpa.":  NewRR("$ORIGIN 21.172.in-addr.arpa.\n" + SOA),
-	"22.172.in-addr.arpa.":  NewRR("$ORIGIN 22.172.in-addr.arpa.\n" + SOA),
-	"23.172.in-addr.arpa.":  NewRR("$ORIGIN 23.172.in-addr.arpa.\n" + SOA),
-	"24.172.in-addr.arpa.":  NewRR("$ORIGIN 24.172.in-addr.arpa.\n" + SOA),
-	"25.172.in-addr.arpa.":  NewRR("$ORIGIN 25.172.in-addr.arpa.\n" + SOA),
-	"26.172.in-addr.arpa.":  NewRR("$ORIGIN 26.172.in-addr.arpa.\n" + SOA),
-	"27.172.in-addr.arpa.":  NewRR("$ORIGIN 27.172.in-addr.arpa.\n" + SOA),
-	"28.172.in-addr.arpa.":  NewRR("$ORIGIN 28.172.in-addr.arpa.\n" + SOA),
-	"29.172.in-addr.arpa.":  NewRR("$ORIGIN 29.172.in-addr.arpa.\n" + SOA),
-	"30.172.in-addr.arpa.":  NewRR("$ORIGIN 30.172.in-addr.arpa.\n" + SOA),
-	"31.172.in-addr.arpa.":  NewRR("$ORIGIN 31.172.in-addr.arpa.\n" + SOA),
+// blackholes are the name servers of the AS112 zones, see RFC 7534. They are
+// replaced by the ones given with -ns.
+var blackholes = []string{"blackhole-1.iana.org.", "blackhole-2.iana.org."}
+
+// names is a list of domain names, the flag can be repeated.
+type names []string
+
+func (n *names) String() string { return strings.Join(*n, ",") }
+
+func (n *names) Set(s string) error {
+	if _, ok := dns.IsDomainName(s); !ok {
+		return fmt.Errorf("invalid domain name: %q", s)
+	}
+	*n = append(*n, dns.Fqdn(s))
+	return nil
 }
 
+// zones are the zones that are served empty.
+var zones = []string{
+	"10.in-addr.arpa.",
+	"254.169.in-addr.arpa.",
+	"168.192.in-addr.arpa.",
+	"16.172.in-addr.arpa.",
+	"17.172.in-addr.arpa.",
+	"18.172.in-addr.arpa.",
+	"19.172.in-addr.arpa.",
+	"20.172.in-addr.arpa.",
+	"21.172.in-addr.arpa.",
+	"22.172.in-addr.arpa.",
+	"23.172.in-addr.arpa.",
+	"24.172.in-addr.arpa.",
+	"25.172.in-addr.arpa.",
+	"26.172.in-addr.arpa.",
+	"27.172.in-addr.arpa.",
+	"28.172.in-addr.arpa.",
+	"29.172.in-addr.arpa.",
+	"30.172.in-addr.arpa.",
+	"31.172.in-addr.arpa.",
+}
+
+// hostname is the zone that tells who operates the node.
+const hostname = "hostname.as112.net."
+
+// AS112 redirection, RFC 7535: a zone is delegated here by a DNAME pointing
+// to empty.as112.arpa, the queries for it arrive as names below that zone.
+// The name server is blackhole.as112.arpa, hostname.as112.arpa is the
+// counterpart of hostname.as112.net.
+const (
+	empty        = "empty.as112.arpa."
+	hostnameArpa = "hostname.as112.arpa."
+)
+
+var blackholeArpa = []string{"blackhole.as112.arpa."}
+
+var (
+	cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
+	port       = flag.Int("port", 8053, "port to run on, when no -listen is given")
+	iface      = flag.String("interface", "", "bind the listeners to this network interface")
+	facility   = flag.String("facility", "", "name of the facility of the node, for "+hostname)
+	city       = flag.String("city", "", "City, Country of the node, for "+hostname)
+	unique     = flag.String("ip", "", "unique IP address of the node, for "+hostname)
+	loc        = flag.String("loc", "", "LOC record data of the node, e.g. \"37 49 18.000 N 122 16 29.000 W 0.00m\", for "+hostname)
+	nsf        names
+	listen     addrs
+	allow      prefixes
+	rrl        = flag.Int("ratelimit", 0, "limit the UDP responses to this many per second per prefix, 0 is unlimited")
+	slip       = flag.Int("slip", 2, "with -ratelimit send every Nth limited response truncated instead of dropping it, 0 drops all")
+	prefix4    = flag.Int("ipv4-prefix", ratelimit.DefaultPrefix4, "with -ratelimit limit IPv4 clients per prefix of this length")
+	prefix6    = flag.Int("ipv6-prefix", ratelimit.DefaultPrefix6, "with -ratelimit limit IPv6 clients per prefix of this length")
+	rrlSize    = flag.Int("ratelimit-size", ratelimit.DefaultSize, "with -ratelimit remember at most this many prefixes, the least recently seen is forgotten first")
+	leak       = flag.Int("leak", 0, "with -ratelimit send every Nth limited response anyway, 0 is never")
+	cert       = flag.String("cert", "", "certificate file for DNS over TLS and HTTPS")
+	key        = flag.String("key", "", "private key file for DNS over TLS and HTTPS")
+	tlsPort    = flag.Int("tls-port", 0, "port for DNS over TLS, 0 is off")
+	httpsPort  = flag.Int("https-port", 0, "port for DNS over HTTPS on /dns-query, 0 is off")
+	health     = flag.String("health", "", "serve /healthz over HTTP on this address, e.g. 127.0.0.1:8080")
+	compress   = flag.Bool("compress", false, "compress replies")
+	minimal    = flag.Bool("minimal-responses", false, "leave out the NS records in the authority section of positive answers and answer ANY with one RRset")
+	userf      = flag.String("user", "", "switch to this user after the listeners are set up")
+	groupf     = flag.String("group", "", "switch to this group after the listeners are set up, default is the group of -user")
+	serialf    = flag.Uint("serial", 0, "SOA serial to use instead of one made from the date (YYYYMMDDnn)")
+	zonesf     = flag.String("zones", "", "file with more zones to serve empty, one per line, it is read again on SIGHUP")
+)
+
 func main() {
-	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
-	//	ratelimit := flag.Bool("ratelimit", false, "ratelimit responses using RRL")
-	port := flag.Int("port", 8053, "port to run on")
+	flag.Var(&listen, "listen", "listen on this IP:port, can be repeated")
+	flag.Var(&allow, "allow", "only answer clients in this prefix, others are refused, can be repeated")
+	flag.Var(&nsf, "ns", "name server for the NS records at the apexes, can be repeated (default "+strings.Join(blackholes, ", ")+")")
 	flag.Parse()
+	if len(nsf) > 0 {
+		blackholes = nsf
+	}
+	if *prefix4 < 0 || *prefix4 > 32 || *prefix6 < 0 || *prefix6 > 128 {
+		log.Fatalf("Invalid -ipv4-prefix %d or -ipv6-prefix %d", *prefix4, *prefix6)
+	}
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -61,33 +183,214 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	for z, rr := range zones {
-		rrx := rr.(*dns.SOA) // Needed to create the actual RR, and not an reference.
-		dns.HandleFunc(z, func(w dns.ResponseWriter, r *dns.Msg) {
-			m := new(dns.Msg)
-			m.SetReply(r)
-			m.Authoritative = true
-			m.Ns = []dns.RR{rrx}
-			w.WriteMsg(m)
-		})
+	m, err := load()
+	if err != nil {
+		log.Fatal(err)
 	}
+	mux.Store(m)
+	handler := allowed(dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) { mux.Load().ServeDNS(w, r) }))
 
-	go func() {
-		srv := &dns.Server{Addr: ":" + strconv.Itoa(*port), Net: "udp"}
-		if err := srv.ListenAndServe(); err != nil {
-			log.Fatalf("Failed to set udp listener %s\n", err.Error())
+	if len(listen) == 0 {
+		listen = addrs{":" + strconv.Itoa(*port)}
+	}
+	var config *tls.Config
+	if *tlsPort > 0 || *httpsPort > 0 {
+		if config, err = tlsConfig(); err != nil {
+			log.Fatal(err)
 		}
-	}()
+	}
 
-	go func() {
-		srv := &dns.Server{Addr: ":" + strconv.Itoa(*port), Net: "tcp"}
-		if err := srv.ListenAndServe(); err != nil {
+	// All sockets are created here, so a failure is seen before we start.
+	l := ratelimit.New(*rrl, *rrl, *slip)
+	l.Leak = *leak
+	l.Prefix4, l.Prefix6 = *prefix4, *prefix6
+	l.Size = *rrlSize
+	var (
+		servers []*dns.Server
+		https   []net.Listener
+	)
+	for _, a := range listen {
+		pc, err := listenUDP(a)
+		if err != nil {
+			log.Fatalf("Failed to set udp listener %s\n", err.Error())
+		}
+		tl, err := listenTCP(a)
+		if err != nil {
 			log.Fatalf("Failed to set tcp listener %s\n", err.Error())
 		}
-	}()
+		servers = append(servers,
+			&dns.Server{Addr: a, Net: "udp", PacketConn: pc, Handler: limited(l, handler)},
+			&dns.Server{Addr: a, Net: "tcp", Listener: tl, Handler: handler},
+		)
+		if *tlsPort > 0 {
+			tl, err := listenTCP(withPort(a, *tlsPort))
+			if err != nil {
+				log.Fatalf("Failed to set tls listener %s\n", err.Error())
+			}
+			servers = append(servers, &dns.Server{Addr: withPort(a, *tlsPort), Net: "tcp-tls", Listener: tls.NewListener(tl, config), Handler: handler})
+		}
+		if *httpsPort > 0 {
+			hl, err := listenTCP(withPort(a, *httpsPort))
+			if err != nil {
+				log.Fatalf("Failed to set https listener %s\n", err.Error())
+			}
+			https = append(https, tls.NewListener(hl, config))
+		}
+	}
+	var hl net.Listener
+	if *health != "" {
+		if hl, err = net.Listen("tcp", *health); err != nil {
+			log.Fatalf("Failed to set health listener %s\n", err.Error())
+		}
+	}
+	// Everything is bound, we don't need root anymore.
+	if err := dropPrivileges(); err != nil {
+		log.Fatalf("Failed to drop privileges: %s", err)
+	}
 
-	sig := make(chan os.Signal)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-	s := <-sig
-	log.Fatalf("Signal (%v) received, stopping\n", s)
+	for _, srv := range servers {
+		go func(srv *dns.Server) {
+			if err := srv.ActivateAndServe(); err != nil {
+				log.Fatalf("Failed to serve %s on %s: %s\n", srv.Net, srv.Addr, err.Error())
+			}
+		}(srv)
+	}
+	doh := &http.Server{Handler: dohHandler(handler)}
+	for _, hl := range https {
+		go func(hl net.Listener) {
+			if err := doh.Serve(hl); err != http.ErrServerClosed {
+				log.Fatalf("Failed to serve https on %s: %s\n", hl.Addr(), err.Error())
+			}
+		}(hl)
+	}
+	hs := &http.Server{Handler: healthHandler(servers)}
+	if hl != nil {
+		go func() {
+			if err := hs.Serve(hl); err != http.ErrServerClosed {
+				log.Fatalf("Failed to serve health on %s: %s\n", hl.Addr(), err.Error())
+			}
+		}()
+	}
+	sdNotify("READY=1")
+	go sdWatchdog()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for s := range sig {
+		if s == syscall.SIGHUP {
+			sdNotify("RELOADING=1")
+			m, err := load()
+			if err != nil {
+				log.Printf("Failed to reload: %s", err)
+			} else {
+				mux.Store(m)
+				log.Printf("Reloaded")
+			}
+			sdNotify("READY=1")
+			continue
+		}
+		log.Printf("Signal (%v) received, stopping\n", s)
+		sdNotify("STOPPING=1")
+		break
+	}
+	// Drain the queries in flight.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.ShutdownContext(ctx)
+	}
+	doh.Shutdown(ctx)
+	hs.Shutdown(ctx)
+}
+
+// mux holds the zones, it is replaced on a reload.
+var mux atomic.Pointer[dns.ServeMux]
+
+// serial is the serial of the SOA records, it is set by load.
+var serial uint32
+
+// nextSerial returns the serial in the YYYYMMDDnn form for now, it is always
+// larger than prev. Unless -serial is given.
+func nextSerial(prev uint32, now time.Time) uint32 {
+	if *serialf > 0 {
+		return uint32(*serialf)
+	}
+	s := uint32(now.Year()*1000000 + int(now.Month())*10000 + now.Day()*100)
+	if s <= prev {
+		s = prev + 1
+	}
+	return s
+}
+
+// load creates the zones, the ones from -zones are added to the AS112 zones.
+// The SOA serial is made from the date, on every reload it is increased.
+func load() (*dns.ServeMux, error) {
+	serial = nextSerial(serial, time.Now().UTC())
+	all := zones
+	if *zonesf != "" {
+		extra, err := readZones(*zonesf)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all[:len(all):len(all)], extra...)
+	}
+	m := dns.NewServeMux()
+	for _, z := range all {
+		m.Handle(z, newZone(z, SOA, blackholes))
+	}
+	m.Handle(empty, newZone(empty, SOA7535, blackholeArpa))
+	for _, h := range []struct {
+		origin, soa string
+		ns          []string
+	}{{hostname, SOA, blackholes}, {hostnameArpa, SOA7535, blackholeArpa}} {
+		z, err := hostnameZone(h.origin, h.soa, h.ns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s: %s", h.origin, err)
+		}
+		m.Handle(h.origin, z)
+	}
+	return m, nil
+}
+
+// readZones reads the zone names in file, one per line. Empty lines and lines
+// starting with # are skipped.
+func readZones(file string) ([]string, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var zs []string
+	for i, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, ok := dns.IsDomainName(line); !ok {
+			return nil, fmt.Errorf("%s:%d: invalid domain name: %q", file, i+1, line)
+		}
+		zs = append(zs, strings.ToLower(dns.Fqdn(line)))
+	}
+	return zs, nil
+}
+
+// limited limits the responses of h with l.
+func limited(l *ratelimit.Limiter, h dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		a, ok := w.RemoteAddr().(*net.UDPAddr)
+		if !ok {
+			h.ServeDNS(w, r)
+			return
+		}
+		switch l.Limit(a.IP, time.Now()) {
+		case ratelimit.Drop:
+			return
+		case ratelimit.Slip:
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Truncated = true
+			w.WriteMsg(m)
+			return
+		}
+		h.ServeDNS(w, r)
+	})
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+// tlsConfig returns the TLS configuration for DNS over TLS and HTTPS, with the
+// certificate from -cert and -key.
+func tlsConfig() (*tls.Config, error) {
+	if *cert == "" || *key == "" {
+		return nil, errors.New("-cert and -key are needed for DNS over TLS and HTTPS")
+	}
+	c, err := tls.LoadX509KeyPair(*cert, *key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{c}}, nil
+}
+
+// dohHandler serves DNS over HTTPS (RFC 8484) on /dns-query with h, both GET
+// and POST requests are understood.
+func dohHandler(h dns.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dns-query", func(w http.ResponseWriter, req *http.Request) {
+		var (
+			buf []byte
+			err error
+		)
+		switch req.Method {
+		case http.MethodGet:
+			buf, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if req.Header.Get("Content-Type") != "application/dns-message" {
+				http.Error(w, "content type must be application/dns-message", http.StatusUnsupportedMediaType)
+				return
+			}
+			buf, err = io.ReadAll(io.LimitReader(req.Body, dns.MaxMsgSize))
+		default:
+			http.Error(w, "method must be GET or POST", http.StatusMethodNotAllowed)
+			return
+		}
+		m := new(dns.Msg)
+		if err == nil {
+			err = m.Unpack(buf)
+		}
+		if err != nil || len(m.Question) != 1 {
+			http.Error(w, "bad DNS message", http.StatusBadRequest)
+			return
+		}
+
+		dw := &dohWriter{}
+		dw.local, _ = req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		dw.remote, _ = net.ResolveTCPAddr("tcp", req.RemoteAddr)
+		h.ServeDNS(dw, m)
+		if dw.reply == nil {
+			http.Error(w, "no reply", http.StatusInternalServerError)
+			return
+		}
+		out, err := dw.reply.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(minTTL(dw.reply))))
+		w.Write(out)
+	})
+	return mux
+}
+
+// minTTL returns the lowest TTL in the answer and authority sections of m,
+// which is how long an HTTP cache may keep the reply.
+func minTTL(m *dns.Msg) uint32 {
+	var ttl uint32
+	for i, rr := range append(m.Answer[:len(m.Answer):len(m.Answer)], m.Ns...) {
+		if i == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	return ttl
+}
+
+// dohWriter is the dns.ResponseWriter for a DNS over HTTPS request, it keeps
+// the reply.
+type dohWriter struct {
+	local, remote net.Addr
+	reply         *dns.Msg
+}
+
+func (w *dohWriter) LocalAddr() net.Addr       { return w.local }
+func (w *dohWriter) RemoteAddr() net.Addr      { return w.remote }
+func (w *dohWriter) WriteMsg(m *dns.Msg) error { w.reply = m; return nil }
+func (w *dohWriter) Close() error              { return nil }
+func (w *dohWriter) TsigStatus() error         { return nil }
+func (w *dohWriter) TsigTimersOnly(bool)       {}
+func (w *dohWriter) Hijack()                   {}
+
+func (w *dohWriter) Write(buf []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		return 0, err
+	}
+	w.reply = m
+	return len(buf), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// started is when as112 was started, for the uptime.
+var started = time.Now()
+
+// listenerStatus is the status of one listener in the /healthz reply.
+type listenerStatus struct {
+	Net   string `json:"net"`
+	Addr  string `json:"addr"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// healthHandler serves /healthz, it queries every listener in servers for the
+// SOA of hostname.as112.net. When one of them fails the status is 503, so a
+// load balancer or an anycast health checker can withdraw the node.
+func healthHandler(servers []*dns.Server) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		health := struct {
+			Status    string           `json:"status"`
+			Uptime    string           `json:"uptime"`
+			Listeners []listenerStatus `json:"listeners"`
+		}{Status: "ok", Uptime: time.Since(started).Round(time.Second).String()}
+		for _, srv := range servers {
+			ls := listenerStatus{Net: srv.Net, Addr: srv.Addr, OK: true}
+			if err := probe(srv); err != nil {
+				ls.OK, ls.Error = false, err.Error()
+				health.Status = "failing"
+			}
+			health.Listeners = append(health.Listeners, ls)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if health.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(health)
+	})
+	return mux
+}
+
+// probe queries srv for the SOA of hostname.as112.net.
+func probe(srv *dns.Server) error {
+	var a net.Addr
+	switch {
+	case srv.PacketConn != nil:
+		a = srv.PacketConn.LocalAddr()
+	case srv.Listener != nil:
+		a = srv.Listener.Addr()
+	default:
+		return fmt.Errorf("not listening")
+	}
+	host, port, _ := net.SplitHostPort(a.String())
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+		if ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	c := &dns.Client{Net: srv.Net, Timeout: time.Second}
+	if srv.Net == "tcp-tls" {
+		c.TLSConfig = &tls.Config{InsecureSkipVerify: true} // it's us
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(hostname, dns.TypeSOA)
+	r, _, err := c.Exchange(m, net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) == 0 {
+		return fmt.Errorf("no SOA for %s: %s", hostname, dns.RcodeToString[r.Rcode])
+	}
+	return nil
+}
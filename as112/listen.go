@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// addrs is a list of addresses to listen on, the flag can be repeated.
+type addrs []string
+
+func (a *addrs) String() string { return strings.Join(*a, ",") }
+
+func (a *addrs) Set(s string) error {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return err
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("not an IP address: %q", host)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port: %q", port)
+	}
+	*a = append(*a, s)
+	return nil
+}
+
+// withPort returns addr with its port replaced by port.
+func withPort(addr string, port int) string {
+	host, _, _ := net.SplitHostPort(addr)
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+var lc = net.ListenConfig{Control: control}
+
+func listenUDP(addr string) (net.PacketConn, error) {
+	return lc.ListenPacket(context.Background(), "udp", addr)
+}
+
+func listenTCP(addr string) (net.Listener, error) {
+	return lc.Listen(context.Background(), "tcp", addr)
+}
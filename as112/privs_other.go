@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// dropPrivileges returns an error when -user or -group is used, this is only
+// implemented on Unix.
+func dropPrivileges() error {
+	if *userf != "" || *groupf != "" {
+		return errors.New("-user and -group are only supported on Unix")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges switches to the group given with -group and the user given
+// with -user, which are names or numbers. Without -group the primary group of
+// the user is used.
+func dropPrivileges() error {
+	if *userf == "" && *groupf == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if *userf != "" {
+		u, err := user.Lookup(*userf)
+		if err != nil {
+			if u, err = user.LookupId(*userf); err != nil {
+				return fmt.Errorf("unknown user %q", *userf)
+			}
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+	if *groupf != "" {
+		g, err := user.LookupGroup(*groupf)
+		if err != nil {
+			if g, err = user.LookupGroupId(*groupf); err != nil {
+				return fmt.Errorf("unknown group %q", *groupf)
+			}
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return err
+	}
+	if uid >= 0 {
+		return syscall.Setuid(uid)
+	}
+	return nil
+}
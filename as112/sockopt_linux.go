@@ -0,0 +1,19 @@
+package main
+
+import "syscall"
+
+// control binds the sockets as112 creates to the interface given with
+// -interface.
+func control(network, address string, c syscall.RawConn) error {
+	if *iface == "" {
+		return nil
+	}
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, *iface)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// control returns an error when -interface is used, binding to an interface
+// is only implemented on Linux.
+func control(network, address string, c syscall.RawConn) error {
+	if *iface != "" {
+		return errors.New("-interface is only supported on Linux")
+	}
+	return nil
+}
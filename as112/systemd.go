@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends state to systemd, see sd_notify(3). Without NOTIFY_SOCKET in
+// the environment we are not started by systemd and nothing is sent.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if addr[0] == '@' { // abstract socket
+		addr = "\x00" + addr[1:]
+	}
+	co, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer co.Close()
+	_, err = co.Write([]byte(state))
+	return err
+}
+
+// sdWatchdog sends WATCHDOG=1 to systemd at half the interval systemd asked
+// for with WATCHDOG_USEC, when the watchdog is enabled for us.
+func sdWatchdog() {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	for range time.Tick(time.Duration(usec) * time.Microsecond / 2) {
+		sdNotify("WATCHDOG=1")
+	}
+}
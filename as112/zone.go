@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// zone is one of the zones served by the AS112 node.
+type zone struct {
+	origin string
+	soa    *dns.SOA
+	rrs    map[string][]dns.RR // by lower cased owner name
+}
+
+// newZone returns the zone origin with the SOA record soa and the NS records
+// for the names in ns at the apex.
+func newZone(origin, soa string, ns []string) *zone {
+	z := &zone{origin: origin, rrs: map[string][]dns.RR{}}
+	z.soa = NewRR("$ORIGIN " + origin + "\n" + soa).(*dns.SOA)
+	z.soa.Serial = serial
+	z.add(z.soa)
+	for _, n := range ns {
+		z.add(&dns.NS{Hdr: dns.RR_Header{Name: origin, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: z.soa.Hdr.Ttl}, Ns: n})
+	}
+	return z
+}
+
+func (z *zone) add(rr ...dns.RR) {
+	for _, r := range rr {
+		n := strings.ToLower(r.Header().Name)
+		z.rrs[n] = append(z.rrs[n], r)
+	}
+}
+
+// ServeDNS answers from the records in the zone. A name that doesn't exist
+// gets NXDOMAIN, an existing name without the type NODATA, both with the SOA
+// in the authority section. A positive answer has the NS records of the zone
+// in the authority section, unless -minimal-responses is given; then ANY only
+// gets one RRset as well.
+func (z *zone) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	m.Compress = *compress
+	q := r.Question[0]
+	name := strings.ToLower(q.Name)
+	for _, rr := range z.rrs[name] {
+		t := rr.Header().Rrtype
+		switch {
+		case q.Qtype == t:
+		case q.Qtype == dns.TypeANY && !*minimal:
+		case q.Qtype == dns.TypeANY && (len(m.Answer) == 0 || m.Answer[0].Header().Rrtype == t):
+		default:
+			continue
+		}
+		m.Answer = append(m.Answer, rr)
+	}
+	switch {
+	case len(m.Answer) == 0:
+		if !z.exists(name) {
+			m.Rcode = dns.RcodeNameError
+		}
+		m.Ns = []dns.RR{z.negative()}
+	case !*minimal && !(name == z.origin && q.Qtype == dns.TypeNS):
+		for _, rr := range z.rrs[z.origin] {
+			if rr.Header().Rrtype == dns.TypeNS {
+				m.Ns = append(m.Ns, rr)
+			}
+		}
+	}
+	w.WriteMsg(m)
+}
+
+// exists returns true when name has records or is an empty non-terminal.
+func (z *zone) exists(name string) bool {
+	if len(z.rrs[name]) > 0 {
+		return true
+	}
+	for n := range z.rrs {
+		if strings.HasSuffix(n, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// negative returns the SOA for a negative answer, its TTL is the negative TTL:
+// the lower of its own TTL and the minimum field, see RFC 2308, section 3.
+func (z *zone) negative() dns.RR {
+	soa := dns.Copy(z.soa)
+	soa.Header().Ttl = min(z.soa.Hdr.Ttl, z.soa.Minttl)
+	return soa
+}
+
+// hostnameZone returns the hostname.as112.net or hostname.as112.arpa zone,
+// it tells who operates the node, see RFC 7534, section 3.5.
+func hostnameZone(origin, soa string, ns []string) (*zone, error) {
+	z := newZone(origin, soa, ns)
+	txt := func(s ...string) dns.RR {
+		return &dns.TXT{Hdr: dns.RR_Header{Name: origin, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: z.soa.Hdr.Ttl}, Txt: s}
+	}
+	if *facility != "" || *city != "" {
+		z.add(txt(*facility, *city))
+	}
+	z.add(txt("See http://www.as112.net/ for more information."))
+	if *unique != "" {
+		z.add(txt("Unique IP: " + *unique + "."))
+	}
+	if *loc != "" {
+		rr, err := dns.NewRR(origin + " LOC " + *loc)
+		if err != nil {
+			return nil, err
+		}
+		rr.Header().Ttl = z.soa.Hdr.Ttl
+		z.add(rr)
+	}
+	return z, nil
+}
@@ -2,62 +2,225 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Chaos is a small program that prints the version.bind and hostname.bind
-// for each address of the nameserver given as argument.
+// Chaos is a small program that prints the identity of each address of the
+// nameserver given as argument, as returned by the CH TXT queries for
+// version.bind, hostname.bind, id.server, version.server and authors.bind.
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/miekg/dns"
 )
 
+var (
+	nsid    = flag.Bool("nsid", false, "also send an IN query with the NSID option and show the NSID")
+	workers = flag.Int("workers", 8, "number of addresses to query concurrently")
+	port    = flag.Int("p", 53, "port to send the queries to")
+	timeout = flag.Duration("timeout", 2*time.Second, "timeout of a single query")
+	retries = flag.Int("retries", 1, "number of times to retry a query that failed")
+	count   = flag.Int("count", 1, "query this many times and summarize the hostname.bind and NSID values seen")
+	every   = flag.Duration("interval", time.Second, "time between the queries with -count")
+)
+
 func main() {
-	if len(os.Args) != 2 {
-		log.Fatalf("%s NAMESERVER\n", os.Args[0])
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s [options] [@resolver] NAMESERVER\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	var name, resolver string
+	for _, arg := range flag.Args() {
+		if strings.HasPrefix(arg, "@") {
+			resolver = arg[1:]
+			continue
+		}
+		if name != "" {
+			flag.Usage()
+			os.Exit(1)
+		}
+		name = arg
+	}
+	if name == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	c := &dns.Client{Timeout: *timeout}
+
+	var addr []string
+	if ip := net.ParseIP(strings.Trim(name, "[]")); ip != nil {
+		// A literal address is queried directly.
+		addr = []string{net.JoinHostPort(ip.String(), strconv.Itoa(*port))}
+	} else {
+		addr = addresses(resolverConfig(resolver), c, name)
+	}
+	if len(addr) == 0 {
+		log.Fatalf("No address found for %s\n", name)
+	}
+
+	if *count > 1 {
+		sample(c, addr)
+		return
+	}
+
+	// Query the addresses concurrently, each one writes to its own buffer
+	// and these are printed in order.
+	out := make([]bytes.Buffer, len(addr))
+	parallel(len(addr), func(i int) { query(&out[i], c, addr[i]) })
+	for i := range out {
+		os.Stdout.Write(out[i].Bytes())
+	}
+}
+
+// parallel calls f(i) for i in [0, n) with at most -workers calls running at
+// the same time, and returns when all are done.
+func parallel(n int, f func(i int)) {
+	sem := make(chan struct{}, max(*workers, 1))
+	wg := new(sync.WaitGroup)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// resolverConfig returns the configuration for the resolver given with
+// @resolver, with an optional port, or the one from /etc/resolv.conf.
+func resolverConfig(resolver string) *dns.ClientConfig {
+	if resolver != "" {
+		conf := &dns.ClientConfig{Servers: []string{strings.Trim(resolver, "[]")}, Port: "53"}
+		if host, p, err := net.SplitHostPort(resolver); err == nil {
+			conf.Servers[0], conf.Port = host, p
+		}
+		return conf
 	}
 	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
 	if err != nil {
 		log.Fatal("error making client from default file", err)
 	}
+	return conf
+}
 
+// query asks the address a for its identities and writes the table to out.
+func query(out io.Writer, c *dns.Client, a string) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "%s\n", a)
 	m := &dns.Msg{
 		Question: make([]dns.Question, 1),
 	}
+	for _, id := range identities {
+		m.Question[0] = dns.Question{Name: id, Qtype: dns.TypeTXT, Qclass: dns.ClassCHAOS}
+		m.Id = dns.Id()
+		in, rtt, err := exchange(c, m, a)
+		fmt.Fprintf(w, "  %s\t%s\t(time %.3d µs)\n", id, identity(in, err), rtt/1e3)
+	}
+	if *nsid {
+		in, rtt, err := exchange(c, nsidQuery(), a)
+		fmt.Fprintf(w, "  %s\t%s\t(time %.3d µs)\n", "NSID", nsidString(in, err), rtt/1e3)
+	}
+}
 
-	c := new(dns.Client)
+// identities are the CH TXT names that name servers answer with their
+// identity, different vendors support different ones.
+var identities = []string{"version.bind.", "hostname.bind.", "id.server.", "version.server.", "authors.bind."}
 
-	addr := addresses(conf, c, os.Args[1])
-	if len(addr) == 0 {
-		log.Fatalf("No address found for %s\n", os.Args[1])
+// identity returns the TXT data from the reply in, or why there is none.
+func identity(in *dns.Msg, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return dns.RcodeToString[in.Rcode]
+	}
+	var txt []string
+	for _, rr := range in.Answer {
+		if t, ok := rr.(*dns.TXT); ok {
+			txt = append(txt, strings.Join(t.Txt, " "))
+		}
+	}
+	if len(txt) == 0 {
+		return "-"
+	}
+	return strings.Join(txt, ", ")
+}
+
+// nsidQuery returns an IN query for the root SOA with an empty NSID option,
+// servers with NSID enabled will put their identifier in the reply.
+func nsidQuery() *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(".", dns.TypeSOA)
+	m.RecursionDesired = false
+	m.SetEdns0(dns.DefaultMsgSize, false)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	return m
+}
+
+// nsidString returns the NSID from the reply in, as text when it is printable
+// and followed by the hex encoding.
+func nsidString(in *dns.Msg, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	opt := in.IsEdns0()
+	if opt == nil {
+		return "no EDNS0"
 	}
-	for _, a := range addr {
-		m.Question[0] = dns.Question{"version.bind.", dns.TypeTXT, dns.ClassCHAOS}
-		in, rtt, err := c.Exchange(m, a)
-		if err != nil {
-			fmt.Println(err)
+	for _, o := range opt.Option {
+		n, ok := o.(*dns.EDNS0_NSID)
+		if !ok {
+			continue
 		}
-		if in != nil && len(in.Answer) > 0 {
-			log.Printf("(time %.3d µs) %v\n", rtt/1e3, in.Answer[0])
+		b, err := hex.DecodeString(n.Nsid)
+		if err != nil || !printable(b) {
+			return n.Nsid
 		}
-		m.Question[0] = dns.Question{"hostname.bind.", dns.TypeTXT, dns.ClassCHAOS}
-		in, rtt, err = c.Exchange(m, a)
-		if err != nil {
-			fmt.Println(err)
+		return fmt.Sprintf("%s (%s)", b, n.Nsid)
+	}
+	return "-"
+}
+
+func printable(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return false
 		}
-		if in != nil && len(in.Answer) > 0 {
-			log.Printf("(time %.3d µs) %v\n", rtt/1e3, in.Answer[0])
+	}
+	return len(b) > 0
+}
+
+// exchange sends m to addr, when that fails it is retried up to -retries
+// times.
+func exchange(c *dns.Client, m *dns.Msg, addr string) (r *dns.Msg, rtt time.Duration, err error) {
+	for i := 0; i <= *retries; i++ {
+		if r, rtt, err = c.Exchange(m, addr); err == nil {
+			return r, rtt, nil
 		}
 	}
+	return r, rtt, err
 }
 
 func do(t chan *dns.Msg, wg *sync.WaitGroup, c *dns.Client, m *dns.Msg, addr string) {
 	defer wg.Done()
-	r, _, err := c.Exchange(m, addr)
+	r, _, err := exchange(c, m, addr)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -67,9 +230,9 @@ func do(t chan *dns.Msg, wg *sync.WaitGroup, c *dns.Client, m *dns.Msg, addr str
 
 func addresses(conf *dns.ClientConfig, c *dns.Client, name string) (ips []string) {
 	m4 := new(dns.Msg)
-	m4.SetQuestion(dns.Fqdn(os.Args[1]), dns.TypeA)
+	m4.SetQuestion(dns.Fqdn(name), dns.TypeA)
 	m6 := new(dns.Msg)
-	m6.SetQuestion(dns.Fqdn(os.Args[1]), dns.TypeAAAA)
+	m6.SetQuestion(dns.Fqdn(name), dns.TypeAAAA)
 	t := make(chan *dns.Msg, 2)
 
 	wg := new(sync.WaitGroup)
@@ -84,10 +247,9 @@ func addresses(conf *dns.ClientConfig, c *dns.Client, name string) (ips []string
 			for _, a := range d.Answer {
 				switch t := a.(type) {
 				case *dns.A:
-					ips = append(ips, net.JoinHostPort(t.A.String(), "53"))
+					ips = append(ips, net.JoinHostPort(t.A.String(), strconv.Itoa(*port)))
 				case *dns.AAAA:
-					ips = append(ips, net.JoinHostPort(t.AAAA.String(), "53"))
-
+					ips = append(ips, net.JoinHostPort(t.AAAA.String(), strconv.Itoa(*port)))
 				}
 			}
 		}
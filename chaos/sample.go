@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// samples holds the values one address returned for an identity, over all
+// rounds.
+type samples struct {
+	seen    map[string]int
+	last    string
+	changes int
+}
+
+func (s *samples) add(v string) {
+	if s.seen == nil {
+		s.seen = map[string]int{}
+	} else if v != s.last {
+		s.changes++
+	}
+	s.seen[v]++
+	s.last = v
+}
+
+// sample queries hostname.bind, and the NSID with -nsid, of every address
+// -count times, -interval apart. For anycast addresses the distinct values
+// show which instances are reached and how often that changes.
+func sample(c *dns.Client, addr []string) {
+	host := make([]samples, len(addr))
+	id := make([]samples, len(addr))
+	for round := 0; round < *count; round++ {
+		if round > 0 {
+			time.Sleep(*every)
+		}
+		parallel(len(addr), func(i int) {
+			m := new(dns.Msg)
+			m.Question = []dns.Question{{Name: "hostname.bind.", Qtype: dns.TypeTXT, Qclass: dns.ClassCHAOS}}
+			m.Id = dns.Id()
+			in, _, err := exchange(c, m, addr[i])
+			host[i].add(identity(in, err))
+			if *nsid {
+				in, _, err := exchange(c, nsidQuery(), addr[i])
+				id[i].add(nsidString(in, err))
+			}
+		})
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	for i, a := range addr {
+		fmt.Fprintf(w, "%s\n", a)
+		summarize(w, "hostname.bind.", host[i])
+		if *nsid {
+			summarize(w, "NSID", id[i])
+		}
+	}
+}
+
+// summarize prints the distinct values in s, the most frequent first.
+func summarize(w *tabwriter.Writer, name string, s samples) {
+	values := make([]string, 0, len(s.seen))
+	for v := range s.seen {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if s.seen[values[i]] != s.seen[values[j]] {
+			return s.seen[values[i]] > s.seen[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	for _, v := range values {
+		fmt.Fprintf(w, "  %s\t%s\t%d/%d\n", name, v, s.seen[v], *count)
+	}
+	fmt.Fprintf(w, "  %s\t%d distinct, %d changes\t\n", name, len(values), s.changes)
+}
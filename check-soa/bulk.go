@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// bulk checks all zones listed in file and prints a one line summary for each
+// of them. It returns false when a zone fails the check.
+func bulk(c *dns.Client, file string) bool {
+	var in io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Printf("Cannot read the zones: %s\n", err)
+			return false
+		}
+		defer f.Close()
+		in = f
+	}
+	var zones []string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		zones = append(zones, dns.Fqdn(strings.Fields(line)[0]))
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("Cannot read the zones: %s\n", err)
+		return false
+	}
+
+	summaries := make([]string, len(zones))
+	failed := make([]bool, len(zones))
+	servers := make([][]*nameserver, len(zones))
+	parallel(len(zones), func(i int) {
+		var err error
+		if servers[i], err = checkZone(c, zones[i]); err != nil {
+			summaries[i], failed[i] = fmt.Sprintf("%s FAIL %s", zones[i], err), true
+			return
+		}
+		summaries[i], failed[i] = summary(zones[i], servers[i])
+	})
+
+	success := true
+	for i := range zones {
+		fmt.Println(summaries[i])
+		success = success && !failed[i]
+	}
+	if *promFile != "" {
+		if err := writeProm(*promFile, zones, servers); err != nil {
+			fmt.Printf("Cannot write %s: %s\n", *promFile, err)
+			return false
+		}
+	}
+	return success
+}
+
+// summary returns a line for zone saying whether all addresses answered
+// authoritatively with the same serial, and whether that is a failure.
+func summary(zone string, servers []*nameserver) (string, bool) {
+	var (
+		addrs, answered int
+		serials         []uint32
+	)
+	seen := map[uint32]bool{}
+	for _, ns := range servers {
+		addrs += len(ns.ips)
+		for _, res := range ns.results {
+			if !res.auth {
+				continue
+			}
+			answered++
+			if !seen[res.serial] {
+				seen[res.serial] = true
+				serials = append(serials, res.serial)
+			}
+		}
+	}
+	switch {
+	case answered == 0:
+		return fmt.Sprintf("%s FAIL no authoritative answer from %d addresses", zone, addrs), true
+	case len(serials) > 1:
+		s := make([]string, len(serials))
+		for i := range serials {
+			s[i] = fmt.Sprintf("%d", serials[i])
+		}
+		return fmt.Sprintf("%s FAIL serial drift %s, %d/%d addresses answered", zone, strings.Join(s, " "), answered, addrs), true
+	case answered < addrs:
+		return fmt.Sprintf("%s FAIL serial %d, %d/%d addresses answered", zone, serials[0], answered, addrs), true
+	}
+	return fmt.Sprintf("%s OK serial %d, %d/%d addresses answered", zone, serials[0], answered, addrs), false
+}
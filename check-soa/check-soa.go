@@ -3,10 +3,15 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
@@ -19,15 +24,41 @@ const (
 )
 
 var (
-	localm *dns.Msg
 	localc *dns.Client
 	conf   *dns.ClientConfig
+	// ctx is canceled when the -deadline for the whole run expires.
+	ctx = context.Background()
+)
+
+var (
+	workers    = flag.Int("workers", 8, "number of name server addresses to query concurrently")
+	dateSerial = flag.Bool("date-serial", false, "show the lag in days when the serials look like YYYYMMDDnn")
+	retries    = flag.Int("retries", 2, "number of times to retry a query over UDP before trying TCP")
+	backoff    = flag.Duration("backoff", 500*time.Millisecond, "wait before the first retry, doubled for every next one")
+	timeout    = flag.Duration("timeout", DefaultTimeout, "timeout of a single query")
+	deadline   = flag.Duration("deadline", 0, "maximum time for the whole run, 0 means no limit")
+	port       = flag.Int("port", 53, "port of the resolver given with @server")
+	bufsize    = flag.Int("bufsize", 1232, "EDNS0 buffer size of the SOA queries, 0 disables EDNS0")
+	audit      = flag.Bool("audit", false, "report EDNS0, DO bit, RRSIG and TCP support of every server")
+	zoneFile   = flag.String("f", "", "check the zones in this file, one per line, \"-\" reads from stdin")
+	tcpCheck   = flag.Bool("tcp", false, "repeat the SOA query over TCP to every address, RFC 7766 requires TCP support")
+	dnssec     = flag.Bool("dnssec", false, "set the DO bit and validate the RRSIG over the SOA from every server")
+	sigWarn    = flag.Duration("sig-warn", 7*24*time.Hour, "with -dnssec, warn when a signature expires within this time")
+	tlsf       = flag.Bool("tls", false, "query the SOA over DNS over TLS first, falling back to port 53")
+	soaFields  = flag.Bool("soa", false, "check the fields of the SOA record against the recommended values")
+	promFile   = flag.String("prom", "", "write the serials and reachability to this file in the Prometheus text format")
+	delegation = flag.Bool("delegation", false, "compare the NS records and glue in the parent zone with the ones in the zone")
 )
 
 func localQuery(qname string, qtype uint16) (*dns.Msg, error) {
+	localm := &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			RecursionDesired: true,
+		},
+	}
 	localm.SetQuestion(qname, qtype)
 	for _, server := range conf.Servers {
-		r, _, err := localc.Exchange(localm, server+":"+conf.Port)
+		r, _, err := localc.ExchangeContext(ctx, localm, net.JoinHostPort(server, conf.Port))
 		if err != nil {
 			return nil, err
 		}
@@ -38,131 +69,495 @@ func localQuery(qname string, qtype uint16) (*dns.Msg, error) {
 	return nil, errors.New("No name server to answer the question")
 }
 
-func main() {
-	if len(os.Args) != 2 {
-		fmt.Printf("%s ZONE\n", os.Args[0])
-		os.Exit(1)
+// nameserver is a name server of the zone with its addresses and the result of
+// the SOA query for each address.
+type nameserver struct {
+	name    string
+	err     string // error getting the addresses
+	ips     []string
+	results []soaResult
+	ok      bool // at least one address returned a SOA
+}
+
+// soaResult is the outcome of the SOA query to one address.
+type soaResult struct {
+	text   string
+	serial uint32
+	auth   bool // serial is set and comes from an authoritative answer
+	rtt    time.Duration
+	soa    *dns.SOA // set when auth is true
+	tcpErr error    // set when -tcp is used and the query over TCP failed
+	answer []dns.RR // answer section with the SOA and, with -dnssec, its RRSIGs
+}
+
+// addresses looks up the IPv4 and IPv6 addresses of ns.
+func addresses(ns *nameserver) {
+	ra, err := localQuery(ns.name, dns.TypeA)
+	if err != nil || ra == nil {
+		ns.err = fmt.Sprintf("Error getting the IPv4 address of %s: %s", ns.name, err)
+		return
 	}
-	var err error
-	conf, err = dns.ClientConfigFromFile("/etc/resolv.conf")
-	if err != nil || conf == nil {
-		fmt.Printf("Cannot initialize the local resolver: %s\n", err)
-		os.Exit(1)
+	if ra.Rcode != dns.RcodeSuccess {
+		ns.err = fmt.Sprintf("Error getting the IPv4 address of %s: %s", ns.name, dns.RcodeToString[ra.Rcode])
+		return
 	}
-	localm = &dns.Msg{
-		MsgHdr: dns.MsgHdr{
-			RecursionDesired: true,
-		},
-		Question: make([]dns.Question, 1),
+	for _, ansa := range ra.Answer {
+		switch ansb := ansa.(type) {
+		case *dns.A:
+			ns.ips = append(ns.ips, ansb.A.String())
+		}
 	}
-	localc = &dns.Client{
-		ReadTimeout: DefaultTimeout,
+	raaaa, err := localQuery(ns.name, dns.TypeAAAA)
+	if err != nil || raaaa == nil {
+		ns.err = fmt.Sprintf("Error getting the IPv6 address of %s: %s", ns.name, err)
+		return
 	}
-	r, err := localQuery(dns.Fqdn(os.Args[1]), dns.TypeNS)
-	if err != nil || r == nil {
-		fmt.Printf("Cannot retrieve the list of name servers for %s: %s\n", dns.Fqdn(os.Args[1]), err)
-		os.Exit(1)
+	if raaaa.Rcode != dns.RcodeSuccess {
+		ns.err = fmt.Sprintf("Error getting the IPv6 address of %s: %s", ns.name, dns.RcodeToString[raaaa.Rcode])
+		return
 	}
-	if r.Rcode == dns.RcodeNameError {
-		fmt.Printf("No such domain %s\n", dns.Fqdn(os.Args[1]))
-		os.Exit(1)
+	for _, ansaaaa := range raaaa.Answer {
+		switch tansaaaa := ansaaaa.(type) {
+		case *dns.AAAA:
+			ns.ips = append(ns.ips, tansaaaa.AAAA.String())
+		}
 	}
+	ns.results = make([]soaResult, len(ns.ips))
+}
+
+// checkSOA queries ip, an address of the name server name, for the SOA of zone
+// and returns what to print for it and whether a SOA was returned. With -tls
+// DoT is tried first.
+func checkSOA(c *dns.Client, zone, name, ip string) (soaResult, bool) {
 	m := &dns.Msg{
 		MsgHdr: dns.MsgHdr{
 			RecursionDesired: false,
 		},
-		Question: make([]dns.Question, 1),
+	}
+	m.SetQuestion(zone, dns.TypeSOA)
+	m.RecursionDesired = false
+	switch {
+	case *dnssec:
+		m.SetEdns0(uint16(max(*bufsize, 1232)), true)
+	case *bufsize > 0:
+		m.SetEdns0(uint16(*bufsize), false)
+	}
+	if !*tlsf {
+		soa, rtt, err := exchange(c, m, net.JoinHostPort(ip, "53"))
+		return soaReply(ip, soa, rtt, err)
+	}
+	soa, rtt, transport, err := exchangeTLS(c, m, name, ip)
+	if err != nil {
+		transport = fmt.Sprintf("no DoT: %s", err)
+		soa, rtt, err = exchange(c, m, net.JoinHostPort(ip, "53"))
+	}
+	res, ok := soaReply(ip, soa, rtt, err)
+	res.text += " [" + transport + "]"
+	return res, ok
+}
+
+// soaReply returns the result for the SOA query to ip that returned soa.
+func soaReply(ip string, soa *dns.Msg, rtt time.Duration, err error) (soaResult, bool) {
+	if err != nil || soa == nil {
+		return soaResult{text: fmt.Sprintf("%s (%s)", ip, err)}, false
+	}
+	if soa.Rcode != dns.RcodeSuccess {
+		return soaResult{text: fmt.Sprintf("%s (%s)", ip, dns.RcodeToString[soa.Rcode])}, false
+	}
+	if len(soa.Answer) == 0 { // May happen if the server is a recursor, not authoritative, since we query with RD=0
+		return soaResult{text: fmt.Sprintf("%s (0 answer)", ip)}, false
+	}
+	if t, ok := soa.Answer[0].(*dns.SOA); ok {
+		if soa.Authoritative {
+			return soaResult{text: fmt.Sprintf("%s (%d, %s)", ip, t.Serial, rtt), serial: t.Serial, auth: true, rtt: rtt, soa: t, answer: soa.Answer}, true
+		}
+		return soaResult{text: fmt.Sprintf("%s (not authoritative, %s)", ip, rtt), rtt: rtt}, true
+	}
+	return soaResult{text: fmt.Sprintf("%s (no SOA)", ip)}, false
+}
+
+// capabilities queries ip for the SOA of zone with the DO bit set, once over
+// UDP and once over TCP, and returns which of EDNS0, DO, RRSIGs and TCP the
+// server supports. A missing capability is prefixed with "no-".
+func capabilities(c *dns.Client, zone, ip string) string {
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeSOA)
+	m.RecursionDesired = false
+	m.SetEdns0(dns.DefaultMsgSize, true)
+	server := net.JoinHostPort(ip, "53")
+
+	var edns, do, sig bool
+	if r, _, err := exchange(c, m, server); err == nil {
+		if opt := r.IsEdns0(); opt != nil {
+			edns = true
+			do = opt.Do()
+		}
+		for _, rr := range r.Answer {
+			if s, ok := rr.(*dns.RRSIG); ok && s.TypeCovered == dns.TypeSOA {
+				sig = true
+			}
+		}
+	}
+	tc := *c
+	tc.Net = "tcp"
+	_, _, err := tc.ExchangeContext(ctx, m, server)
+
+	caps := []string{}
+	for _, cp := range []struct {
+		name string
+		ok   bool
+	}{{"edns", edns}, {"do", do}, {"rrsig", sig}, {"tcp", err == nil}} {
+		if cp.ok {
+			caps = append(caps, cp.name)
+		} else {
+			caps = append(caps, "no-"+cp.name)
+		}
+	}
+	return "[" + strings.Join(caps, ",") + "]"
+}
+
+// checkTCP queries ip for the SOA of zone over TCP, without retries or a
+// fallback, and returns why that failed.
+func checkTCP(c *dns.Client, zone, ip string) error {
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeSOA)
+	m.RecursionDesired = false
+	tc := *c
+	tc.Net = "tcp"
+	r, _, err := tc.ExchangeContext(ctx, m, net.JoinHostPort(ip, "53"))
+	if err != nil {
+		return err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("%s", dns.RcodeToString[r.Rcode])
+	}
+	if len(r.Answer) == 0 {
+		return fmt.Errorf("0 answer")
+	}
+	return nil
+}
+
+// reportTCP prints the addresses that failed the SOA query over TCP. It
+// returns false if there are any.
+func reportTCP(servers []*nameserver) bool {
+	ok := true
+	for _, ns := range servers {
+		for i, res := range ns.results {
+			if res.tcpErr != nil {
+				fmt.Printf("TCP: %s %s fails over TCP: %s\n", ns.name, ns.ips[i], res.tcpErr)
+				ok = false
+			}
+		}
+	}
+	if ok {
+		fmt.Printf("TCP: all addresses answer over TCP\n")
+	}
+	return ok
+}
+
+// exchange sends m to server over UDP, retrying with exponential backoff so a
+// single lost packet isn't reported as an unreachable server. When all UDP
+// attempts fail, or the reply is truncated, TCP is tried once. The round trip
+// time of the exchange that succeeded is returned.
+func exchange(c *dns.Client, m *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	var (
+		r   *dns.Msg
+		rtt time.Duration
+		err error
+	)
+	wait := *backoff
+	for i := 0; i <= *retries; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, 0, ctx.Err()
+			}
+			wait *= 2
+		}
+		if r, rtt, err = c.ExchangeContext(ctx, m, server); err == nil {
+			break
+		}
+	}
+	if err == nil && !r.Truncated {
+		return r, rtt, nil
+	}
+	tc := *c
+	tc.Net = "tcp"
+	r1, rtt1, err1 := tc.ExchangeContext(ctx, m, server)
+	switch {
+	case err1 == nil:
+		return r1, rtt1, nil
+	case err == nil:
+		return nil, 0, fmt.Errorf("truncated, TCP failed: %s", err1)
+	}
+	return nil, 0, err
+}
+
+// parallel calls f(i) for i in [0, n) with at most *workers calls running at
+// the same time, and returns when all are done.
+func parallel(n int, f func(i int)) {
+	limit := *workers
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Printf("%s [options] [@server] ZONE\n", os.Args[0])
+		fmt.Printf("%s [options] [@server] -f FILE\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	var zone, resolver string
+	for _, arg := range flag.Args() {
+		if strings.HasPrefix(arg, "@") {
+			resolver = strings.Trim(arg[1:], "[]")
+			continue
+		}
+		if zone != "" {
+			flag.Usage()
+			os.Exit(1)
+		}
+		zone = dns.Fqdn(arg)
+	}
+	if (zone == "") == (*zoneFile == "") {
+		flag.Usage()
+		os.Exit(1)
+	}
+	var err error
+	if resolver != "" {
+		// The resolver given on the command line, no need for /etc/resolv.conf.
+		conf = &dns.ClientConfig{Servers: []string{resolver}, Port: strconv.Itoa(*port)}
+	} else {
+		conf, err = dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil || conf == nil {
+			fmt.Printf("Cannot initialize the local resolver: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	localc = &dns.Client{
+		Timeout: *timeout,
 	}
 	c := &dns.Client{
-		ReadTimeout: DefaultTimeout,
+		Timeout: *timeout,
+	}
+	if *deadline > 0 {
+		// Queries still running when the deadline expires fail, what has
+		// been found so far is printed.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
+
+	if *zoneFile != "" {
+		if !bulk(c, *zoneFile) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	servers, err := checkZone(c, zone)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
 	}
 	var success bool
-	var numNS int
-	for _, ans := range r.Answer {
-		switch t := ans.(type) {
-		case *dns.NS:
-			nameserver := t.Ns
-			numNS++
-			var ips []string
-			fmt.Printf("%s : ", nameserver)
-			ra, err := localQuery(nameserver, dns.TypeA)
-			if err != nil || ra == nil {
-				fmt.Printf("Error getting the IPv4 address of %s: %s\n", nameserver, err)
-				os.Exit(1)
+	for _, ns := range servers {
+		fmt.Printf("%s : ", ns.name)
+		switch {
+		case ns.err != "":
+			fmt.Printf("%s", ns.err)
+		case len(ns.ips) == 0:
+			fmt.Printf("No IP address for this server")
+		default:
+			for _, res := range ns.results {
+				fmt.Printf("%s ", res.text)
 			}
-			if ra.Rcode != dns.RcodeSuccess {
-				fmt.Printf("Error getting the IPv4 address of %s: %s\n", nameserver, dns.RcodeToString[ra.Rcode])
-				os.Exit(1)
-			}
-			for _, ansa := range ra.Answer {
-				switch ansb := ansa.(type) {
-				case *dns.A:
-					ips = append(ips, ansb.A.String())
-				}
+		}
+		fmt.Printf("\n")
+		success = success || ns.ok
+	}
+	drift(servers)
+	rtts(servers)
+	if *tcpCheck && !reportTCP(servers) {
+		success = false
+	}
+	if *dnssec && !checkSignatures(c, zone, servers) {
+		success = false
+	}
+	if *soaFields && !checkFields(zone, servers) {
+		success = false
+	}
+	if *promFile != "" {
+		if err := writeProm(*promFile, []string{zone}, [][]*nameserver{servers}); err != nil {
+			fmt.Printf("Cannot write %s: %s\n", *promFile, err)
+			success = false
+		}
+	}
+	if *delegation && !checkDelegation(c, zone, servers) {
+		success = false
+	}
+	if !success {
+		os.Exit(1)
+	}
+}
+
+// checkZone looks up the name servers of zone and their addresses, and queries
+// all of them for the SOA. The servers are returned in the order of the NS
+// records.
+func checkZone(c *dns.Client, zone string) ([]*nameserver, error) {
+	r, err := localQuery(zone, dns.TypeNS)
+	if err != nil || r == nil {
+		return nil, fmt.Errorf("Cannot retrieve the list of name servers for %s: %s", zone, err)
+	}
+	if r.Rcode == dns.RcodeNameError {
+		return nil, fmt.Errorf("No such domain %s", zone)
+	}
+
+	var servers []*nameserver
+	for _, ans := range r.Answer {
+		if t, ok := ans.(*dns.NS); ok {
+			servers = append(servers, &nameserver{name: t.Ns})
+		}
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("No NS records for %q. It is probably a CNAME to a domain but not a zone", zone)
+	}
+
+	// Look up all addresses and then query all of them. A server whose lookup
+	// failed is not queried, its error is printed instead.
+	parallel(len(servers), func(i int) { addresses(servers[i]) })
+	type job struct{ ns, ip int }
+	var jobs []job
+	for i, ns := range servers {
+		if ns.err != "" {
+			continue
+		}
+		for j := range ns.ips {
+			jobs = append(jobs, job{i, j})
+		}
+	}
+	var mu sync.Mutex
+	parallel(len(jobs), func(i int) {
+		ns := servers[jobs[i].ns]
+		res, ok := checkSOA(c, zone, ns.name, ns.ips[jobs[i].ip])
+		if *audit && ok {
+			res.text += " " + capabilities(c, zone, ns.ips[jobs[i].ip])
+		}
+		if *tcpCheck {
+			res.tcpErr = checkTCP(c, zone, ns.ips[jobs[i].ip])
+		}
+		mu.Lock()
+		ns.results[jobs[i].ip] = res
+		ns.ok = ns.ok || ok
+		mu.Unlock()
+	})
+	return servers, nil
+}
+
+// drift prints whether all name servers have the same serial, and if not
+// which addresses lag behind the newest serial.
+func drift(servers []*nameserver) {
+	var (
+		newest uint32
+		found  bool
+	)
+	for _, ns := range servers {
+		for _, res := range ns.results {
+			if !res.auth {
+				continue
 			}
-			raaaa, err := localQuery(nameserver, dns.TypeAAAA)
-			if err != nil || raaaa == nil {
-				fmt.Printf("Error getting the IPv6 address of %s: %s\n", nameserver, err)
-				os.Exit(1)
+			if !found || serialLess(newest, res.serial) {
+				newest = res.serial
 			}
-			if raaaa.Rcode != dns.RcodeSuccess {
-				fmt.Printf("Error getting the IPv6 address of %s: %s\n", nameserver, dns.RcodeToString[raaaa.Rcode])
-				os.Exit(1)
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+	var lag []string
+	for _, ns := range servers {
+		for i, res := range ns.results {
+			if !res.auth || res.serial == newest {
+				continue
 			}
-			for _, ansaaaa := range raaaa.Answer {
-				switch tansaaaa := ansaaaa.(type) {
-				case *dns.AAAA:
-					ips = append(ips, tansaaaa.AAAA.String())
+			s := fmt.Sprintf("%s %s (%d", ns.name, ns.ips[i], res.serial)
+			if *dateSerial {
+				if days, ok := dateLag(res.serial, newest); ok {
+					s += fmt.Sprintf(", %d days behind", days)
 				}
 			}
-			if len(ips) == 0 {
-				fmt.Printf("No IP address for this server")
+			lag = append(lag, s+")")
+		}
+	}
+	if len(lag) == 0 {
+		fmt.Printf("All name servers have serial %d\n", newest)
+		return
+	}
+	fmt.Printf("Serial drift, newest serial is %d, lagging: %s\n", newest, strings.Join(lag, ", "))
+}
+
+// rtts prints the fastest and the slowest address to answer the SOA query.
+func rtts(servers []*nameserver) {
+	var fastest, slowest string
+	var fastRTT, slowRTT time.Duration
+	for _, ns := range servers {
+		for i, res := range ns.results {
+			if res.rtt == 0 {
+				continue
 			}
-			for _, ip := range ips {
-				m.Question[0] = dns.Question{Name: dns.Fqdn(os.Args[1]), Qtype: dns.TypeSOA, Qclass: dns.ClassINET}
-				m.Id = dns.Id()
-				var nsAddressPort string
-				if strings.ContainsAny(":", ip) {
-					// IPv6 address
-					nsAddressPort = "[" + ip + "]:53"
-				} else {
-					nsAddressPort = ip + ":53"
-				}
-				soa, _, err := c.Exchange(m, nsAddressPort)
-				// TODO: retry if timeout? Otherwise, one lost UDP packet and it is the end
-				if err != nil || soa == nil {
-					fmt.Printf("%s (%s) ", ip, err)
-					goto Next
-				}
-				if soa.Rcode != dns.RcodeSuccess {
-					fmt.Printf("%s (%s) ", ips, dns.RcodeToString[soa.Rcode])
-					goto Next
-				}
-				if len(soa.Answer) == 0 { // May happen if the server is a recursor, not authoritative, since we query with RD=0
-					fmt.Printf("%s (0 answer) ", ip)
-					goto Next
-				}
-				rsoa := soa.Answer[0]
-				switch trsoa := rsoa.(type) {
-				case *dns.SOA:
-					if soa.Authoritative {
-						// TODO: test if all name servers have the same serial ?
-						fmt.Printf("%s (%d) ", ips, trsoa.Serial)
-					} else {
-						fmt.Printf("%s (not authoritative) ", ips)
-					}
-				}
+			if fastest == "" || res.rtt < fastRTT {
+				fastest, fastRTT = ns.name+" "+ns.ips[i], res.rtt
+			}
+			if slowest == "" || res.rtt > slowRTT {
+				slowest, slowRTT = ns.name+" "+ns.ips[i], res.rtt
 			}
-			success = true
-		Next:
-			fmt.Printf("\n")
 		}
 	}
-	if numNS == 0 {
-		fmt.Printf("No NS records for %q. It is probably a CNAME to a domain but not a zone\n", dns.Fqdn(os.Args[1]))
-		os.Exit(1)
+	if fastest == "" {
+		return
 	}
-	if !success {
-		os.Exit(1)
+	fmt.Printf("Response time: min %s (%s), max %s (%s)\n", fastRTT, fastest, slowRTT, slowest)
+}
+
+// serialLess returns true when a is before b in serial number arithmetic,
+// RFC 1982.
+func serialLess(a, b uint32) bool {
+	return a != b && int32(b-a) > 0
+}
+
+// dateLag returns the number of days between two serials in the YYYYMMDDnn
+// format. It returns false when either of them doesn't look like a date.
+func dateLag(old, cur uint32) (int, bool) {
+	to, ok := serialDate(old)
+	if !ok {
+		return 0, false
+	}
+	tn, ok := serialDate(cur)
+	if !ok {
+		return 0, false
+	}
+	return int(tn.Sub(to).Hours() / 24), true
+}
+
+func serialDate(serial uint32) (time.Time, bool) {
+	t, err := time.Parse("20060102", fmt.Sprintf("%d", serial/100))
+	if err != nil || t.Year() < 1990 {
+		return time.Time{}, false
 	}
+	return t, true
 }
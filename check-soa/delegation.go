@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// parentServers returns the zone that is the parent of zone and the names of
+// its name servers. Names without NS records are skipped, so for a.b.example.
+// this can be example. when b.example. is not a zone of its own.
+func parentServers(zone string) (string, []string, error) {
+	parent := zone
+	for parent != "." {
+		i, end := dns.NextLabel(parent, 0)
+		if end {
+			parent = "."
+		} else {
+			parent = parent[i:]
+		}
+		r, err := localQuery(parent, dns.TypeNS)
+		if err != nil {
+			return parent, nil, err
+		}
+		var ns []string
+		for _, rr := range r.Answer {
+			if t, ok := rr.(*dns.NS); ok && dns.CanonicalName(t.Hdr.Name) == dns.CanonicalName(parent) {
+				ns = append(ns, t.Ns)
+			}
+		}
+		if len(ns) > 0 {
+			return parent, ns, nil
+		}
+	}
+	return parent, nil, fmt.Errorf("no name servers for the parent of %s", zone)
+}
+
+// delegationNS asks the name servers of parent for the NS records of zone
+// without recursion. The first server that answers is used, the referral's
+// NS records and its glue are returned.
+func delegationNS(c *dns.Client, zone, parent string, servers []string) ([]string, []dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeNS)
+	m.RecursionDesired = false
+	if *bufsize > 0 {
+		m.SetEdns0(uint16(*bufsize), false)
+	}
+	err := fmt.Errorf("no addresses for the name servers of %s", parent)
+	for _, name := range servers {
+		p := &nameserver{name: name}
+		addresses(p)
+		for _, ip := range p.ips {
+			var r *dns.Msg
+			if r, _, err = exchange(c, m, net.JoinHostPort(ip, "53")); err != nil {
+				continue
+			}
+			if r.Rcode != dns.RcodeSuccess {
+				err = fmt.Errorf("%s from %s", dns.RcodeToString[r.Rcode], ip)
+				continue
+			}
+			var ns []string
+			for _, rr := range append(r.Answer, r.Ns...) {
+				if t, ok := rr.(*dns.NS); ok && dns.CanonicalName(t.Hdr.Name) == dns.CanonicalName(zone) {
+					ns = append(ns, dns.CanonicalName(t.Ns))
+				}
+			}
+			var glue []dns.RR
+			for _, rr := range r.Extra {
+				if rr.Header().Rrtype == dns.TypeA || rr.Header().Rrtype == dns.TypeAAAA {
+					glue = append(glue, rr)
+				}
+			}
+			return ns, glue, nil
+		}
+	}
+	return nil, nil, err
+}
+
+// authServer returns the address of the first server that answered the SOA
+// query authoritatively.
+func authServer(servers []*nameserver) (string, bool) {
+	for _, s := range servers {
+		for i, res := range s.results {
+			if res.auth {
+				return net.JoinHostPort(s.ips[i], "53"), true
+			}
+		}
+	}
+	return "", false
+}
+
+// childNS asks an authoritative server of zone for the NS records at the apex.
+func childNS(c *dns.Client, zone string, servers []*nameserver) ([]string, error) {
+	server, ok := authServer(servers)
+	if !ok {
+		return nil, fmt.Errorf("no authoritative server answered")
+	}
+	r, err := authQuery(c, server, zone, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	var ns []string
+	for _, rr := range r.Answer {
+		if t, ok := rr.(*dns.NS); ok {
+			ns = append(ns, dns.CanonicalName(t.Ns))
+		}
+	}
+	return ns, nil
+}
+
+// authQuery asks server for qname and qtype without recursion, the reply must
+// be authoritative.
+func authQuery(c *dns.Client, server, qname string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(qname, qtype)
+	m.RecursionDesired = false
+	if *bufsize > 0 {
+		m.SetEdns0(uint16(*bufsize), false)
+	}
+	r, _, err := exchange(c, m, server)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("%s from %s", dns.RcodeToString[r.Rcode], server)
+	}
+	if !r.Authoritative {
+		return nil, fmt.Errorf("not authoritative: %s", server)
+	}
+	return r, nil
+}
+
+// checkGlue compares the glue addresses of the in-bailiwick name servers in
+// the referral with the addresses the zone itself has for them, stale and
+// missing glue is reported. It returns false when there is a problem.
+func checkGlue(c *dns.Client, zone string, glue []dns.RR, names []string, servers []*nameserver) bool {
+	server, found := authServer(servers)
+	if !found {
+		return true // already reported by childNS
+	}
+	parentAddrs := map[string]map[string]bool{}
+	for _, rr := range glue {
+		n := dns.CanonicalName(rr.Header().Name)
+		if parentAddrs[n] == nil {
+			parentAddrs[n] = map[string]bool{}
+		}
+		switch t := rr.(type) {
+		case *dns.A:
+			parentAddrs[n][t.A.String()] = true
+		case *dns.AAAA:
+			parentAddrs[n][t.AAAA.String()] = true
+		}
+	}
+
+	ok := true
+	for _, n := range names {
+		if !dns.IsSubDomain(zone, n) {
+			continue
+		}
+		childAddrs := map[string]bool{}
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			r, err := authQuery(c, server, n, qtype)
+			if err != nil {
+				fmt.Printf("Glue: cannot get the addresses of %s from %s: %s\n", n, zone, err)
+				ok = false
+				continue
+			}
+			for _, rr := range r.Answer {
+				switch t := rr.(type) {
+				case *dns.A:
+					childAddrs[t.A.String()] = true
+				case *dns.AAAA:
+					childAddrs[t.AAAA.String()] = true
+				}
+			}
+		}
+		for _, a := range sortedKeys(parentAddrs[n]) {
+			if !childAddrs[a] {
+				fmt.Printf("Glue: %s has stale glue %s in the parent, it is not in %s\n", n, a, zone)
+				ok = false
+			}
+		}
+		for _, a := range sortedKeys(childAddrs) {
+			if !parentAddrs[n][a] {
+				fmt.Printf("Glue: %s has address %s in %s, but there is no glue for it in the parent\n", n, a, zone)
+				ok = false
+			}
+		}
+	}
+	return ok
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkDelegation compares the NS records of zone in its parent with the ones
+// at the apex of the zone, and reports the servers that are only listed on one
+// side and the ones that don't answer authoritatively. The glue is checked as
+// well. It returns false when there is a problem.
+func checkDelegation(c *dns.Client, zone string, servers []*nameserver) bool {
+	parent, pservers, err := parentServers(zone)
+	if err != nil {
+		fmt.Printf("Delegation: cannot find the name servers of %s: %s\n", parent, err)
+		return false
+	}
+	pns, glue, err := delegationNS(c, zone, parent, pservers)
+	if err != nil {
+		fmt.Printf("Delegation: cannot get the NS records of %s from %s: %s\n", zone, parent, err)
+		return false
+	}
+	cns, err := childNS(c, zone, servers)
+	if err != nil {
+		fmt.Printf("Delegation: cannot get the NS records of %s from its servers: %s\n", zone, err)
+		return false
+	}
+
+	inParent := map[string]bool{}
+	for _, n := range pns {
+		inParent[n] = true
+	}
+	inChild := map[string]bool{}
+	for _, n := range cns {
+		inChild[n] = true
+	}
+	// Servers that answered authoritatively for the SOA, others are lame.
+	known, auth := map[string]bool{}, map[string]bool{}
+	for _, s := range servers {
+		known[dns.CanonicalName(s.name)] = true
+		for _, res := range s.results {
+			if res.auth {
+				auth[dns.CanonicalName(s.name)] = true
+			}
+		}
+	}
+
+	all := map[string]bool{}
+	for n := range inParent {
+		all[n] = true
+	}
+	for n := range inChild {
+		all[n] = true
+	}
+	names := sortedKeys(all)
+
+	ok := true
+	for _, n := range names {
+		switch {
+		case !inChild[n]:
+			fmt.Printf("Delegation: %s is listed in %s but not in %s (missing at the child)\n", n, parent, zone)
+			ok = false
+		case !inParent[n]:
+			fmt.Printf("Delegation: %s is listed in %s but not in %s (extra at the child)\n", n, zone, parent)
+			ok = false
+		}
+		if !known[n] {
+			auth[n] = answersAuth(c, zone, n)
+		}
+		if !auth[n] {
+			fmt.Printf("Delegation: %s is lame, it does not answer authoritatively for %s\n", n, zone)
+			ok = false
+		}
+	}
+	if ok {
+		fmt.Printf("Delegation: %s and %s agree on %d name servers\n", parent, zone, len(names))
+	}
+	return checkGlue(c, zone, glue, sortedKeys(inParent), servers) && ok
+}
+
+// answersAuth returns true when one of the addresses of the server name
+// answers authoritatively for the SOA of zone.
+func answersAuth(c *dns.Client, zone, name string) bool {
+	s := &nameserver{name: name}
+	addresses(s)
+	for _, ip := range s.ips {
+		if res, _ := checkSOA(c, zone, name, ip); res.auth {
+			return true
+		}
+	}
+	return false
+}
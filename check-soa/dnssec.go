@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// zoneKeys fetches the DNSKEY RRset of zone from an authoritative server.
+func zoneKeys(c *dns.Client, zone string, servers []*nameserver) ([]dns.RR, error) {
+	server, ok := authServer(servers)
+	if !ok {
+		return nil, fmt.Errorf("no authoritative server answered")
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeDNSKEY)
+	m.RecursionDesired = false
+	m.SetEdns0(uint16(max(*bufsize, 1232)), true)
+	r, _, err := exchange(c, m, server)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("%s from %s", dns.RcodeToString[r.Rcode], server)
+	}
+	var keys []dns.RR
+	for _, rr := range r.Answer {
+		if _, ok := rr.(*dns.DNSKEY); ok {
+			keys = append(keys, rr)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no DNSKEY records from %s", server)
+	}
+	return keys, nil
+}
+
+// checkSignatures validates the RRSIGs over the SOA returned by every server
+// with the DNSKEYs of zone, and prints how long each signature remains
+// valid. Signatures that fail to validate or expire within -sig-warn are
+// reported, it returns false if there are any.
+func checkSignatures(c *dns.Client, zone string, servers []*nameserver) bool {
+	keys, err := zoneKeys(c, zone, servers)
+	if err != nil {
+		fmt.Printf("DNSSEC: cannot get the DNSKEY records of %s: %s\n", zone, err)
+		return false
+	}
+
+	ok := true
+	now := time.Now().UTC()
+	for _, ns := range servers {
+		for i, res := range ns.results {
+			if !res.auth {
+				continue
+			}
+			var soa []dns.RR
+			var sigs []*dns.RRSIG
+			for _, rr := range res.answer {
+				switch t := rr.(type) {
+				case *dns.SOA:
+					soa = append(soa, t)
+				case *dns.RRSIG:
+					if t.TypeCovered == dns.TypeSOA {
+						sigs = append(sigs, t)
+					}
+				}
+			}
+			if len(sigs) == 0 {
+				fmt.Printf("DNSSEC: %s %s returns no RRSIG for the SOA\n", ns.name, ns.ips[i])
+				ok = false
+				continue
+			}
+			for _, sig := range sigs {
+				status, good := verifySig(sig, soa, keys, now)
+				fmt.Printf("DNSSEC: %s %s signature by key %d %s\n", ns.name, ns.ips[i], sig.KeyTag, status)
+				ok = ok && good
+			}
+		}
+	}
+	return ok
+}
+
+// verifySig checks sig over rrset with the key it names, and returns a
+// description of the outcome and whether it is acceptable.
+func verifySig(sig *dns.RRSIG, rrset, keys []dns.RR, now time.Time) (string, bool) {
+	var key *dns.DNSKEY
+	for _, rr := range keys {
+		if k := rr.(*dns.DNSKEY); k.KeyTag() == sig.KeyTag && k.Algorithm == sig.Algorithm {
+			key = k
+		}
+	}
+	if key == nil {
+		return "has no matching DNSKEY", false
+	}
+	if err := sig.Verify(key, rrset); err != nil {
+		return fmt.Sprintf("does not validate: %s", err), false
+	}
+	if !sig.ValidityPeriod(now) {
+		return "is outside of its validity period", false
+	}
+	expiration, _ := time.Parse("20060102150405", dns.TimeToString(sig.Expiration))
+	left := expiration.Sub(now)
+	if left < *sigWarn {
+		return fmt.Sprintf("expires in %s", left.Round(time.Minute)), false
+	}
+	return fmt.Sprintf("is valid, expires in %d days", int(left.Hours()/24)), true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Recommended ranges for the SOA timers, after RIPE-203 with the minimum
+// (negative caching TTL) from RFC 2308.
+var soaRanges = []struct {
+	name     string
+	field    func(*dns.SOA) uint32
+	min, max uint32
+}{
+	{"refresh", func(s *dns.SOA) uint32 { return s.Refresh }, 1200, 86400},
+	{"retry", func(s *dns.SOA) uint32 { return s.Retry }, 120, 7200},
+	{"expire", func(s *dns.SOA) uint32 { return s.Expire }, 1209600, 2419200},
+	{"minimum", func(s *dns.SOA) uint32 { return s.Minttl }, 300, 86400},
+}
+
+// checkFields checks the SOA record of the first authoritative server: the
+// MNAME must resolve and be one of the name servers, the RNAME must be a
+// mailbox and the timers must be within the recommended ranges. A warning is
+// printed for every violation, it returns false if there is any.
+func checkFields(zone string, servers []*nameserver) bool {
+	var soa *dns.SOA
+	for _, ns := range servers {
+		for _, res := range ns.results {
+			if soa == nil && res.auth {
+				soa = res.soa
+			}
+		}
+	}
+	if soa == nil {
+		return true // nothing to check, the lack of answers is reported already
+	}
+
+	ok := true
+	warn := func(format string, a ...interface{}) {
+		fmt.Printf("SOA: "+format+"\n", a...)
+		ok = false
+	}
+
+	listed := false
+	for _, ns := range servers {
+		if dns.CanonicalName(ns.name) == dns.CanonicalName(soa.Ns) {
+			listed = true
+		}
+	}
+	if !listed {
+		warn("MNAME %s is not one of the NS records of %s", soa.Ns, zone)
+	}
+	mname := &nameserver{name: soa.Ns}
+	if addresses(mname); mname.err != "" || len(mname.ips) == 0 {
+		warn("MNAME %s does not resolve", soa.Ns)
+	}
+
+	if _, valid := dns.IsDomainName(soa.Mbox); !valid || dns.CountLabel(soa.Mbox) < 2 || strings.Contains(soa.Mbox, "@") {
+		warn("RNAME %s is not a valid mailbox", soa.Mbox)
+	}
+
+	for _, r := range soaRanges {
+		if v := r.field(soa); v < r.min || v > r.max {
+			warn("%s %d is outside of the recommended range %d-%d", r.name, v, r.min, r.max)
+		}
+	}
+	if soa.Retry >= soa.Refresh {
+		warn("retry %d is not smaller than refresh %d", soa.Retry, soa.Refresh)
+	}
+	if soa.Expire < 7*soa.Refresh {
+		warn("expire %d is less than seven times refresh %d", soa.Expire, soa.Refresh)
+	}
+	if ok {
+		fmt.Printf("SOA: fields of %s are fine\n", zone)
+	}
+	return ok
+}
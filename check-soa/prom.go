@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeProm writes the serial and reachability of every address of the zones
+// to file in the Prometheus text format, for the node exporter's textfile
+// collector. The file is replaced atomically so a scrape never sees half of it.
+func writeProm(file string, zones []string, servers [][]*nameserver) error {
+	var b strings.Builder
+	b.WriteString("# HELP dns_soa_serial SOA serial returned by the name server address.\n")
+	b.WriteString("# TYPE dns_soa_serial gauge\n")
+	for i, zone := range zones {
+		for _, ns := range servers[i] {
+			for j, res := range ns.results {
+				if res.auth {
+					fmt.Fprintf(&b, "dns_soa_serial{%s} %d\n", promLabels(zone, ns.name, ns.ips[j]), res.serial)
+				}
+			}
+		}
+	}
+	b.WriteString("# HELP dns_soa_up Whether the name server address answered authoritatively for the SOA.\n")
+	b.WriteString("# TYPE dns_soa_up gauge\n")
+	for i, zone := range zones {
+		for _, ns := range servers[i] {
+			for j, res := range ns.results {
+				up := 0
+				if res.auth {
+					up = 1
+				}
+				fmt.Fprintf(&b, "dns_soa_up{%s} %d\n", promLabels(zone, ns.name, ns.ips[j]), up)
+			}
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".check-soa")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func promLabels(zone, ns, ip string) string {
+	return fmt.Sprintf("zone=%q,ns=%q,ip=%q", zone, ns, ip)
+}
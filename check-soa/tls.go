@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// exchangeTLS sends m to ip on port 853 over TLS. Authoritative servers are
+// rarely set up with a certificate that validates, so the connection is
+// opportunistic (RFC 9539): the certificate is checked against name after the
+// handshake and the outcome is returned as the transport description.
+func exchangeTLS(c *dns.Client, m *dns.Msg, name, ip string) (*dns.Msg, time.Duration, string, error) {
+	tc := *c
+	tc.Net = "tcp-tls"
+	tc.TLSConfig = &tls.Config{
+		ServerName:         strings.TrimSuffix(name, "."),
+		InsecureSkipVerify: true,
+	}
+	co, err := tc.DialContext(ctx, net.JoinHostPort(ip, "853"))
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer co.Close()
+
+	transport := "DoT"
+	if !verified(co.Conn.(*tls.Conn).ConnectionState(), tc.TLSConfig.ServerName) {
+		transport = "DoT, unauthenticated"
+	}
+	r, rtt, err := tc.ExchangeWithConnContext(ctx, m, co)
+	return r, rtt, transport, err
+}
+
+// verified returns true when the certificate chain of the connection is valid
+// for name.
+func verified(cs tls.ConnectionState, name string) bool {
+	if len(cs.PeerCertificates) == 0 {
+		return false
+	}
+	opts := x509.VerifyOptions{DNSName: name, Intermediates: x509.NewCertPool()}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err == nil
+}
@@ -0,0 +1,227 @@
+// Package dnstap logs DNS messages in the dnstap format (https://dnstap.info).
+// The frames are written with the frame streams protocol to a file or to a
+// TCP receiver, the latter gets the bidirectional handshake. The protobuf
+// encoding is done by hand, only the fields of the Message type are used.
+package dnstap
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message types, see Log.
+const (
+	AuthQuery      = 1
+	AuthResponse   = 2
+	ClientQuery    = 5
+	ClientResponse = 6
+)
+
+// Socket protocols.
+const (
+	UDP = 1
+	TCP = 2
+	DoT = 3
+	DoH = 4
+)
+
+// Socket families.
+const (
+	inet  = 1
+	inet6 = 2
+)
+
+// Frame streams control frame types and the content type of dnstap.
+const (
+	fstrmAccept = 1
+	fstrmStart  = 2
+	fstrmStop   = 3
+	fstrmReady  = 4
+	fstrmFinish = 5
+
+	fstrmContentType = "protobuf:dnstap.Dnstap"
+)
+
+// Writer writes dnstap frames. A nil *Writer logs nothing.
+type Writer struct {
+	identity string
+
+	mu sync.Mutex
+	w  io.WriteCloser
+	bi bool // bidirectional
+}
+
+// Open opens dst, which is a file name or tcp:host:port, identity is put in
+// every frame. Timeout is used for connecting to the TCP receiver.
+func Open(dst, identity string, timeout time.Duration) (*Writer, error) {
+	d := &Writer{identity: identity}
+	if strings.HasPrefix(dst, "tcp:") {
+		co, err := net.DialTimeout("tcp", strings.TrimPrefix(dst, "tcp:"), timeout)
+		if err != nil {
+			return nil, err
+		}
+		d.w, d.bi = co, true
+		if err := d.control(fstrmReady); err != nil {
+			co.Close()
+			return nil, err
+		}
+		if typ, err := readControl(co); err != nil || typ != fstrmAccept {
+			co.Close()
+			if err == nil {
+				err = errors.New("frame streams receiver did not accept")
+			}
+			return nil, err
+		}
+	} else {
+		f, err := os.Create(dst)
+		if err != nil {
+			return nil, err
+		}
+		d.w = f
+	}
+	if err := d.control(fstrmStart); err != nil {
+		d.w.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Close writes the STOP frame and closes the output.
+func (d *Writer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.control(fstrmStop)
+	if d.bi {
+		readControl(d.w.(net.Conn)) // FINISH
+	}
+	return d.w.Close()
+}
+
+// control writes a control frame, all but STOP carry the content type.
+func (d *Writer) control(typ uint32) error {
+	var body []byte
+	body = binary.BigEndian.AppendUint32(body, typ)
+	if typ != fstrmStop && typ != fstrmFinish {
+		body = binary.BigEndian.AppendUint32(body, 1) // content type field
+		body = binary.BigEndian.AppendUint32(body, uint32(len(fstrmContentType)))
+		body = append(body, fstrmContentType...)
+	}
+	buf := binary.BigEndian.AppendUint32(nil, 0) // escape
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(body)))
+	_, err := d.w.Write(append(buf, body...))
+	return err
+}
+
+func readControl(r io.Reader) (uint32, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return 0, err
+	}
+	if binary.BigEndian.Uint32(hdr) != 0 {
+		return 0, errors.New("not a frame streams control frame")
+	}
+	body := make([]byte, binary.BigEndian.Uint32(hdr[4:]))
+	if _, err := io.ReadFull(r, body); err != nil || len(body) < 4 {
+		return 0, errors.New("short frame streams control frame")
+	}
+	return binary.BigEndian.Uint32(body), nil
+}
+
+// Log writes a frame of type typ, AuthQuery or ClientQuery, for the query sent
+// from qaddr to raddr at qt. When reply is not nil a frame of the matching
+// response type is written for the reply at rt.
+func (d *Writer) Log(typ int, qaddr, raddr net.Addr, proto int, query []byte, qt time.Time, reply []byte, rt time.Time) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.frame(d.message(typ, qaddr, raddr, proto, query, qt, nil, time.Time{}))
+	if reply != nil {
+		d.frame(d.message(typ+1, qaddr, raddr, proto, query, qt, reply, rt))
+	}
+}
+
+func (d *Writer) frame(payload []byte) {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	d.w.Write(append(buf, payload...))
+}
+
+// message returns the protobuf encoding of a Dnstap message, qaddr is the
+// query address and raddr the response address.
+func (d *Writer) message(typ int, qaddr, raddr net.Addr, proto int, query []byte, qt time.Time, reply []byte, rt time.Time) []byte {
+	var m []byte
+	m = pbVarint(m, 1, uint64(typ))
+	qip, qport := addrPort(qaddr)
+	rip, rport := addrPort(raddr)
+	family := inet
+	if qip.To4() == nil && rip.To4() == nil {
+		family = inet6
+	}
+	m = pbVarint(m, 2, uint64(family))
+	m = pbVarint(m, 3, uint64(proto))
+	if qip != nil {
+		if family == inet {
+			qip = qip.To4()
+		}
+		m = pbBytes(m, 4, qip)
+	}
+	if rip != nil {
+		if family == inet {
+			rip = rip.To4()
+		}
+		m = pbBytes(m, 5, rip)
+	}
+	m = pbVarint(m, 6, uint64(qport))
+	m = pbVarint(m, 7, uint64(rport))
+	m = pbVarint(m, 8, uint64(qt.Unix()))
+	m = pbFixed32(m, 9, uint32(qt.Nanosecond()))
+	if reply == nil {
+		m = pbBytes(m, 10, query)
+	} else {
+		m = pbVarint(m, 12, uint64(rt.Unix()))
+		m = pbFixed32(m, 13, uint32(rt.Nanosecond()))
+		m = pbBytes(m, 14, reply)
+	}
+
+	var tap []byte
+	tap = pbBytes(tap, 1, []byte(d.identity))
+	tap = pbVarint(tap, 15, 1) // MESSAGE
+	tap = pbBytes(tap, 14, m)
+	return tap
+}
+
+func addrPort(a net.Addr) (net.IP, int) {
+	switch a := a.(type) {
+	case *net.UDPAddr:
+		return a.IP, a.Port
+	case *net.TCPAddr:
+		return a.IP, a.Port
+	}
+	return nil, 0
+}
+
+// Minimal protobuf encoding, only what is needed for dnstap.
+
+func pbKey(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func pbVarint(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(pbKey(b, field, 0), v)
+}
+
+func pbFixed32(b []byte, field int, v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(pbKey(b, field, 5), v)
+}
+
+func pbBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(pbKey(b, field, 2), uint64(len(v)))
+	return append(b, v...)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Trust anchors read with -anchor, these can be DNSKEYs or DS records.
+var (
+	anchorKeys []*dns.DNSKEY
+	anchorDS   []*dns.DS
+)
+
+// trustAnchor is the IANA root-anchors XML format, see RFC 9718.
+type trustAnchor struct {
+	XMLName   xml.Name    `xml:"TrustAnchor"`
+	Zone      string      `xml:"Zone"`
+	KeyDigest []keyDigest `xml:"KeyDigest"`
+}
+
+type keyDigest struct {
+	ValidFrom  string `xml:"validFrom,attr"`
+	ValidUntil string `xml:"validUntil,attr"`
+	KeyTag     uint16 `xml:"KeyTag"`
+	Algorithm  uint8  `xml:"Algorithm"`
+	DigestType uint8  `xml:"DigestType"`
+	Digest     string `xml:"Digest"`
+}
+
+// readAnchor reads the trust anchors from file. This is either a zone file
+// with DNSKEY and/or DS records, or an IANA root-anchors XML file.
+func readAnchor(file string) error {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if b := bytes.TrimSpace(buf); bytes.HasPrefix(b, []byte("<?xml")) || bytes.HasPrefix(b, []byte("<TrustAnchor")) {
+		return readIANA(buf)
+	}
+
+	zp := dns.NewZoneParser(bytes.NewReader(buf), ".", file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		switch x := rr.(type) {
+		case *dns.DNSKEY:
+			anchorKeys = append(anchorKeys, x)
+		case *dns.DS:
+			anchorDS = append(anchorDS, x)
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return err
+	}
+	if len(anchorKeys)+len(anchorDS) == 0 {
+		return fmt.Errorf("no DNSKEY or DS records")
+	}
+	return nil
+}
+
+// readIANA parses the XML in buf and adds the currently valid key digests as
+// DS records.
+func readIANA(buf []byte) error {
+	ta := trustAnchor{}
+	if err := xml.Unmarshal(buf, &ta); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, kd := range ta.KeyDigest {
+		if t, err := time.Parse(time.RFC3339, kd.ValidFrom); err == nil && now.Before(t) {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, kd.ValidUntil); err == nil && now.After(t) {
+			continue
+		}
+		anchorDS = append(anchorDS, &dns.DS{
+			Hdr:        dns.RR_Header{Name: dns.Fqdn(ta.Zone), Rrtype: dns.TypeDS, Class: dns.ClassINET},
+			KeyTag:     kd.KeyTag,
+			Algorithm:  kd.Algorithm,
+			DigestType: kd.DigestType,
+			Digest:     strings.ToUpper(kd.Digest),
+		})
+	}
+	if len(anchorDS) == 0 {
+		return fmt.Errorf("no valid KeyDigest for %q", ta.Zone)
+	}
+	return nil
+}
+
+// findKey returns the DNSKEY with keytag for name and where it came from:
+// "disk" for a DNSKEY anchor, "ds" for a key that is validated by a DS
+// anchor and "net" for a key fetched without any anchor. With -ds keys
+// fetched without an anchor are not used.
+func findKey(name string, keytag uint16, server string, tcp bool) (*dns.DNSKEY, string) {
+	for _, k := range anchorKeys {
+		if k.KeyTag() == keytag && dns.CanonicalName(k.Header().Name) == dns.CanonicalName(name) {
+			return k, "disk"
+		}
+	}
+
+	var ds []*dns.DS
+	for _, d := range anchorDS {
+		if dns.CanonicalName(d.Header().Name) == dns.CanonicalName(name) {
+			ds = append(ds, d)
+		}
+	}
+
+	if len(ds) == 0 && *dsf != "" {
+		fmt.Fprintf(stdout, ";? DNSKEY %s is not covered by -ds, not trusting keys from the network\n", name)
+		return nil, ""
+	}
+	set := getKeys(name, server, tcp)
+	if len(ds) == 0 {
+		for _, rr := range set {
+			if k, ok := rr.(*dns.DNSKEY); ok && k.KeyTag() == keytag {
+				return k, "net"
+			}
+		}
+		return nil, ""
+	}
+
+	if !dsValidated(name, set, ds) {
+		fmt.Fprintf(stdout, ";- DNSKEY %s RRset does not match any DS anchor\n", name)
+		return nil, ""
+	}
+	for _, rr := range set {
+		if k, ok := rr.(*dns.DNSKEY); ok && k.KeyTag() == keytag {
+			return k, "ds"
+		}
+	}
+	return nil, ""
+}
+
+// dsValidated returns true when a key in the DNSKEY RRset matches one of the DS
+// records and that key has signed the RRset.
+func dsValidated(name string, set []dns.RR, ds []*dns.DS) bool {
+	keys := getRRset(set, name, dns.TypeDNSKEY)
+	for _, rr := range set {
+		k, ok := rr.(*dns.DNSKEY)
+		if !ok || !dsMatch(k, ds) {
+			continue
+		}
+		for _, rr1 := range set {
+			sig, ok := rr1.(*dns.RRSIG)
+			if !ok || sig.TypeCovered != dns.TypeDNSKEY || sig.KeyTag != k.KeyTag() {
+				continue
+			}
+			if sig.Verify(k, keys) == nil && sig.ValidityPeriod(time.Now().UTC()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func dsMatch(k *dns.DNSKEY, ds []*dns.DS) bool {
+	for _, d := range ds {
+		if d.KeyTag != k.KeyTag() || d.Algorithm != k.Algorithm {
+			continue
+		}
+		if kd := k.ToDS(d.DigestType); kd != nil && strings.EqualFold(kd.Digest, d.Digest) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// anyExpand queries the types in -any-types for the name in m in parallel and
+// prints the merged answers, labeled per type. Many servers refuse or
+// minimize ANY queries (RFC 8482), this gets the same data client side.
+func anyExpand(c *dns.Client, m *dns.Msg, servers []string) {
+	q := m.Question[0]
+	var msgs []*dns.Msg
+	for _, s := range strings.Split(*anyTypes, ",") {
+		t, ok := dns.StringToType[strings.ToUpper(strings.TrimSpace(s))]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown type in -any-types: %s\n", s)
+			continue
+		}
+		mm := m.Copy()
+		mm.Id = dns.Id()
+		mm.Question[0] = dns.Question{Name: q.Name, Qtype: t, Qclass: q.Qclass}
+		msgs = append(msgs, mm)
+	}
+	n := *parallel
+	if n < len(msgs) {
+		n = len(msgs)
+	}
+
+	total := 0
+	for i, res := range queryParallel(c, msgs, servers, n) {
+		r := <-res
+		t := typeString(msgs[i].Question[0].Qtype)
+		if r.err != nil {
+			fmt.Fprintf(stdout, ";; %s: %s\n", t, r.err.Error())
+			continue
+		}
+		if !*nocomments {
+			fmt.Fprintf(stdout, ";; %s: %s, %d records, %.3f ms\n", t, dns.RcodeToString[r.r.Rcode], len(r.r.Answer), ms(r.rtt))
+		}
+		for _, rr := range r.r.Answer {
+			if *comments {
+				fmt.Fprintf(stdout, "%s%s\n", rr, rrComment(rr))
+				continue
+			}
+			fmt.Fprintf(stdout, "%s\n", rr)
+		}
+		total += len(r.r.Answer)
+	}
+	if !*nocomments {
+		fmt.Fprintf(stdout, "\n;; ANY %s expanded to %d queries, %d records\n", q.Name, len(msgs), total)
+	}
+}
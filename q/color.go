@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// stdout is where all output goes, with -color it colorizes the output.
+var stdout io.Writer = os.Stdout
+
+// ANSI escape sequences used for colorizing.
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+// useColor returns true when the output should be colorized for the value
+// of -color: always, never or auto. With auto, color is used when stdout is a
+// terminal and NO_COLOR is not set.
+func useColor(mode string) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorWriter colorizes the lines written to it.
+type colorWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (c *colorWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for {
+		i := bytes.IndexByte(c.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := io.WriteString(c.w, colorize(string(c.buf[:i]))+"\n"); err != nil {
+			return 0, err
+		}
+		c.buf = c.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes out any incomplete line.
+func (c *colorWriter) Flush() {
+	if len(c.buf) > 0 {
+		io.WriteString(c.w, colorize(string(c.buf)))
+		c.buf = nil
+	}
+}
+
+// colorize returns line with color escapes added.
+func colorize(line string) string {
+	switch {
+	case strings.HasPrefix(line, ";+"):
+		return colorGreen + line + colorReset
+	case strings.HasPrefix(line, ";-"):
+		return colorRed + line + colorReset
+	case strings.HasPrefix(line, ";?"):
+		return colorYellow + line + colorReset
+	case strings.HasPrefix(line, ";; ") && strings.HasSuffix(line, " SECTION:"):
+		return colorBold + line + colorReset
+	case strings.HasPrefix(line, ";; opcode: "):
+		i := strings.Index(line, "status: ")
+		if i < 0 {
+			return line
+		}
+		i += len("status: ")
+		j := strings.IndexByte(line[i:], ',')
+		if j < 0 {
+			return line
+		}
+		status := line[i : i+j]
+		color := colorYellow
+		switch status {
+		case "NOERROR":
+			color = colorGreen
+		case "NXDOMAIN", "SERVFAIL":
+			color = colorRed
+		}
+		return line[:i] + color + status + colorReset + line[i+j:]
+	case strings.HasPrefix(line, ";"), line == "":
+		return line
+	}
+	// A resource record: name, ttl, class, type and rdata, color the ttl.
+	f := strings.SplitN(line, "\t", 3)
+	if len(f) < 3 {
+		return line
+	}
+	return f[0] + "\t" + colorCyan + f[1] + colorReset + "\t" + f[2]
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// compareServers sends m to each of the servers and prints a unified diff of
+// their answers against the answer of the first server that replied. The
+// answers are sorted and with -nottl the TTLs are zeroed before comparing. A
+// SAME or DIFFER verdict is printed at the end.
+func compareServers(c *dns.Client, m *dns.Msg, servers []string) {
+	var (
+		ref       []string
+		refServer string
+		differ    bool
+	)
+	for _, server := range servers {
+		res := exchange(c, m, server)
+		if res.err != nil {
+			fmt.Fprintf(stdout, ";; %s: %s\n", server, res.err.Error())
+			differ = true
+			continue
+		}
+		r, rtt := res.r, res.rtt
+		answer := normalize(r)
+		fmt.Fprintf(stdout, ";; %s: %s, %d answers, query time: %.3d µs", server, dns.RcodeToString[r.Rcode], len(r.Answer), rtt/1e3)
+		if ref == nil {
+			ref, refServer = answer, server
+			fmt.Fprintf(stdout, " (reference)\n")
+			for _, rr := range ref[1:] {
+				fmt.Fprintf(stdout, " %s\n", rr)
+			}
+			continue
+		}
+		diff := unifiedDiff(ref, answer)
+		if diff == nil {
+			fmt.Fprintf(stdout, ", same\n")
+			continue
+		}
+		differ = true
+		fmt.Fprintf(stdout, ", differs from %s\n", refServer)
+		fmt.Fprintf(stdout, "--- %s\n+++ %s\n@@ -1,%d +1,%d @@\n", refServer, server, len(ref), len(answer))
+		for _, d := range diff {
+			fmt.Fprintf(stdout, "%s\n", d)
+		}
+	}
+	if differ {
+		fmt.Fprintf(stdout, ";- DIFFER %s %s\n\n", m.Question[0].Name, typeString(m.Question[0].Qtype))
+		return
+	}
+	fmt.Fprintf(stdout, ";+ SAME %s %s\n\n", m.Question[0].Name, typeString(m.Question[0].Qtype))
+}
+
+// normalize returns the rcode and the answer section of r as sorted lines, the
+// TTLs are set to zero when -nottl is given.
+func normalize(r *dns.Msg) []string {
+	lines := []string{";; status: " + dns.RcodeToString[r.Rcode]}
+	for _, rr := range r.Answer {
+		if *nottl {
+			rr = dns.Copy(rr)
+			rr.Header().Ttl = 0
+		}
+		lines = append(lines, rr.String())
+	}
+	sort.Strings(lines[1:])
+	return lines
+}
+
+// unifiedDiff returns the lines of the sorted a and b prefixed with ' ', '-'
+// or '+'. If a and b are equal nil is returned.
+func unifiedDiff(a, b []string) []string {
+	var (
+		diff []string
+		same = true
+	)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff = append(diff, " "+a[i])
+			i++
+			j++
+		case j == len(b) || i < len(a) && a[i] < b[j]:
+			diff = append(diff, "-"+a[i])
+			same = false
+			i++
+		default:
+			diff = append(diff, "+"+b[j])
+			same = false
+			j++
+		}
+	}
+	if same {
+		return nil
+	}
+	return diff
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// complianceTest is one of the EDNS compliance tests, modelled on the tests
+// of ednscomp (https://ednscomp.isc.org).
+type complianceTest struct {
+	name  string
+	tcp   bool
+	query func(m *dns.Msg)
+	check func(r *dns.Msg) string // returns "" when the reply is correct
+}
+
+var complianceTests = []complianceTest{
+	{"dns", false,
+		func(m *dns.Msg) {},
+		func(r *dns.Msg) string {
+			if r.IsEdns0() != nil {
+				return "OPT in reply to a query without EDNS"
+			}
+			return expectRcode(r, dns.RcodeSuccess)
+		}},
+	{"edns", false,
+		func(m *dns.Msg) { m.SetEdns0(4096, false) },
+		func(r *dns.Msg) string { return expectOPT(r, dns.RcodeSuccess) }},
+	{"edns1", false,
+		func(m *dns.Msg) { m.SetEdns0(4096, false); m.IsEdns0().SetVersion(1) },
+		func(r *dns.Msg) string {
+			if s := expectOPT(r, dns.RcodeBadVers); s != "" {
+				return s
+			}
+			if len(r.Answer) > 0 {
+				return "answer in BADVERS reply"
+			}
+			return ""
+		}},
+	{"ednsopt", false,
+		func(m *dns.Msg) {
+			m.SetEdns0(4096, false)
+			o := m.IsEdns0()
+			o.Option = append(o.Option, &dns.EDNS0_LOCAL{Code: 100})
+		},
+		func(r *dns.Msg) string {
+			if s := expectOPT(r, dns.RcodeSuccess); s != "" {
+				return s
+			}
+			for _, e := range r.IsEdns0().Option {
+				if e.Option() == 100 {
+					return "unknown option echoed"
+				}
+			}
+			return ""
+		}},
+	{"edns1opt", false,
+		func(m *dns.Msg) {
+			m.SetEdns0(4096, false)
+			o := m.IsEdns0()
+			o.SetVersion(1)
+			o.Option = append(o.Option, &dns.EDNS0_LOCAL{Code: 100})
+		},
+		func(r *dns.Msg) string { return expectOPT(r, dns.RcodeBadVers) }},
+	{"ednsflags", false,
+		func(m *dns.Msg) { m.SetEdns0(4096, false); m.IsEdns0().SetZ(0x4000) },
+		func(r *dns.Msg) string {
+			if s := expectOPT(r, dns.RcodeSuccess); s != "" {
+				return s
+			}
+			if r.IsEdns0().Z() != 0 {
+				return "unknown flag echoed"
+			}
+			return ""
+		}},
+	{"do", false,
+		func(m *dns.Msg) { m.SetEdns0(4096, true) },
+		func(r *dns.Msg) string {
+			if s := expectOPT(r, dns.RcodeSuccess); s != "" {
+				return s
+			}
+			if !r.IsEdns0().Do() {
+				return "DO bit not echoed"
+			}
+			return ""
+		}},
+	{"edns@512", false,
+		func(m *dns.Msg) { m.SetEdns0(512, true); m.Question[0].Qtype = dns.TypeDNSKEY },
+		func(r *dns.Msg) string {
+			if r.Truncated {
+				return ""
+			}
+			if r.Len() > 512 {
+				return fmt.Sprintf("reply of %d bytes without TC", r.Len())
+			}
+			return expectOPT(r, dns.RcodeSuccess)
+		}},
+	{"ednstcp", true,
+		func(m *dns.Msg) { m.SetEdns0(4096, false) },
+		func(r *dns.Msg) string { return expectOPT(r, dns.RcodeSuccess) }},
+}
+
+// complianceCheck runs the EDNS compliance tests for zone against the servers
+// and prints a table with the results.
+func complianceCheck(c *dns.Client, zone string, servers []string) {
+	tcp := *c
+	tcp.Net = strings.Replace(c.Net, "udp", "tcp", 1)
+	// Read with the largest buffer, edns@512 must see a reply that is too
+	// large for the bufsize it advertised.
+	udp := *c
+	udp.UDPSize = dns.MaxMsgSize
+	for _, server := range servers {
+		fmt.Fprintf(stdout, ";; EDNS compliance of %s for %s\n", server, zone)
+		var failed []string
+		for _, t := range complianceTests {
+			m := new(dns.Msg)
+			m.SetQuestion(zone, dns.TypeSOA)
+			m.RecursionDesired = false
+			t.query(m)
+			cl := &udp
+			if t.tcp {
+				cl = &tcp
+			}
+			res := exchange(cl, m, server)
+			status := "ok"
+			if res.err != nil {
+				status = "timeout or error: " + res.err.Error()
+			} else if s := t.check(res.r); s != "" {
+				status = s
+			}
+			if status != "ok" {
+				failed = append(failed, t.name)
+			}
+			fmt.Fprintf(stdout, "%-10s %s\n", t.name, status)
+		}
+		if len(failed) == 0 {
+			fmt.Fprintf(stdout, ";+ All tests ok\n\n")
+			continue
+		}
+		fmt.Fprintf(stdout, ";- Failed: %s\n\n", strings.Join(failed, ", "))
+	}
+}
+
+func expectRcode(r *dns.Msg, rcode int) string {
+	if r.Rcode != rcode {
+		return fmt.Sprintf("expected %s, got %s", dns.RcodeToString[rcode], dns.RcodeToString[r.Rcode])
+	}
+	return ""
+}
+
+// expectOPT checks the reply has an OPT RR with version 0 and the rcode. The
+// extended rcode is only known when there is an OPT RR.
+func expectOPT(r *dns.Msg, rcode int) string {
+	o := r.IsEdns0()
+	if o == nil {
+		return "no OPT in reply"
+	}
+	if o.Version() != 0 {
+		return fmt.Sprintf("OPT version %d in reply", o.Version())
+	}
+	return expectRcode(r, rcode)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultServer is the server from the config file or Q_SERVER, it is used
+// when no @server is given.
+var defaultServer string
+
+// presetFlags holds the names of the flags set in the config file or the
+// environment.
+var presetFlags = map[string]bool{}
+
+// flagsSet returns the names of the flags that are set, on the command line,
+// in the config file or in the environment.
+func flagsSet() map[string]bool {
+	set := map[string]bool{}
+	for name := range presetFlags {
+		set[name] = true
+	}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// loadDefaults sets the flag defaults from the config file in
+// $XDG_CONFIG_HOME/q/config (usually ~/.config/q/config) and then from the Q_*
+// environment variables, so that the command line overrides both. Each line
+// in the config file is a flag name without the dash and its value, a boolean
+// flag without a value is set to true. Lines starting with # are comments.
+// The variable for a flag is Q_ and its name in upper case, with - replaced by
+// _, e.g. Q_TIMEOUT_READ. The server to use is set with "server" or Q_SERVER.
+func loadDefaults() error {
+	if dir, err := os.UserConfigDir(); err == nil {
+		if err := loadConfig(filepath.Join(dir, "q", "config")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if s := os.Getenv("Q_SERVER"); s != "" {
+		defaultServer = s
+	}
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		env := "Q_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v, ok := os.LookupEnv(env); ok && err == nil {
+			if e := f.Value.Set(v); e != nil {
+				err = fmt.Errorf("%s: %s", env, e)
+			}
+			presetFlags[f.Name] = true
+		}
+	})
+	return err
+}
+
+func loadConfig(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		name, value, _ := strings.Cut(line, " ")
+		name, value = strings.TrimPrefix(name, "-"), strings.TrimSpace(value)
+		if name == "server" {
+			defaultServer = value
+			continue
+		}
+		fl := flag.Lookup(name)
+		if fl == nil {
+			return fmt.Errorf("%s:%d: unknown option %q", file, i, name)
+		}
+		if value == "" {
+			value = "true"
+		}
+		if err := fl.Value.Set(value); err != nil {
+			return fmt.Errorf("%s:%d: %s", file, i, err)
+		}
+		presetFlags[name] = true
+	}
+	return scanner.Err()
+}
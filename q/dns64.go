@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// The well-known name and the IPv4 addresses it has, see RFC 7050.
+const ipv4only = "ipv4only.arpa."
+
+var ipv4onlyAddrs = []net.IP{net.IPv4(192, 0, 0, 170).To4(), net.IPv4(192, 0, 0, 171).To4()}
+
+// dns64Discover queries the servers for the AAAA records of ipv4only.arpa. and
+// derives the NAT64 prefixes in use (RFC 7050) from the synthesized addresses.
+func dns64Discover(c *dns.Client, servers []string) {
+	m := new(dns.Msg)
+	m.SetQuestion(ipv4only, dns.TypeAAAA)
+	res := queryServers(c, m, servers)
+	if res.err != nil {
+		fmt.Fprintf(stdout, ";; %s\n", res.err.Error())
+		return
+	}
+	if res.r.Rcode != dns.RcodeSuccess {
+		fmt.Fprintf(stdout, ";- DNS64, %s returned %s for %s AAAA\n", res.server, dns.RcodeToString[res.r.Rcode], ipv4only)
+		return
+	}
+	seen := map[string]bool{}
+	for _, rr := range res.r.Answer {
+		aaaa, ok := rr.(*dns.AAAA)
+		if !ok {
+			continue
+		}
+		prefix, err := nat64Prefix(aaaa.AAAA)
+		if err != nil {
+			fmt.Fprintf(stdout, ";- DNS64, %s: %s\n", aaaa.AAAA, err)
+			continue
+		}
+		if !seen[prefix.String()] {
+			fmt.Fprintf(stdout, ";+ DNS64, prefix %s (from %s)\n", prefix, aaaa.AAAA)
+		}
+		seen[prefix.String()] = true
+	}
+	if len(seen) == 0 {
+		fmt.Fprintf(stdout, ";- DNS64, no synthesized AAAA records, %s does not do DNS64\n", res.server)
+	}
+}
+
+// nat64Prefix returns the NAT64 prefix that is used in ip. The prefix length
+// is found by looking for one of the well-known IPv4 addresses at the
+// positions defined in RFC 6052, Section 2.2.
+func nat64Prefix(ip net.IP) (*net.IPNet, error) {
+	ip = ip.To16()
+	for _, l := range []int{96, 64, 56, 48, 40, 32} {
+		v4 := extractIPv4(ip, l)
+		for _, a := range ipv4onlyAddrs {
+			if !v4.Equal(a) {
+				continue
+			}
+			// Bits 64 to 71 must be zero (the "u" octet).
+			if l < 96 && ip[8] != 0 {
+				return nil, fmt.Errorf("non-zero u octet in /%d prefix", l)
+			}
+			mask := net.CIDRMask(l, 128)
+			return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, nil
+		}
+	}
+	return nil, fmt.Errorf("no well-known IPv4 address embedded")
+}
+
+// extractIPv4 returns the IPv4 address embedded in ip for prefix length l,
+// skipping the u octet (byte 8).
+func extractIPv4(ip net.IP, l int) net.IP {
+	v4 := make(net.IP, 0, net.IPv4len)
+	for i := l / 8; len(v4) < net.IPv4len && i < net.IPv6len; i++ {
+		if i == 8 && l < 96 {
+			continue
+		}
+		v4 = append(v4, ip[i])
+	}
+	return v4
+}
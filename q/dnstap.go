@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/miekg/exdns/dnstap"
+)
+
+// tap is used to log the queries and responses with -dnstap.
+var tap *dnstap.Writer
+
+// tapProto returns the dnstap socket protocol for the client's net.
+func tapProto(net string) int {
+	switch {
+	case strings.HasSuffix(net, "-tls"):
+		return dnstap.DoT
+	case strings.HasPrefix(net, "tcp"):
+		return dnstap.TCP
+	}
+	return dnstap.UDP
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/miekg/exdns/dnstap"
+)
+
+// dohClient is the HTTP client used for DNS over HTTPS, it is created on first
+// use so connections are reused between queries. Queries are sent
+// concurrently with -parallel, hence the sync.Once.
+var (
+	dohClient *http.Client
+	dohErr    error
+	dohOnce   sync.Once
+)
+
+// exchangeHTTPS sends m to server with DNS over HTTPS (RFC 8484), using the
+// HTTP version and method from -http-version and -http-method.
+func exchangeHTTPS(c *dns.Client, m *dns.Msg, server string) (res result) {
+	res.server = server
+	dohOnce.Do(func() { dohClient, dohErr = newDoHClient(c) })
+	if dohErr != nil {
+		res.err = dohErr
+		return res
+	}
+
+	var requestMAC string
+	if res.query, requestMAC, res.err = packMsg(c, m); res.err != nil {
+		return res
+	}
+	host, port, _ := net.SplitHostPort(server)
+	url := "https://" + net.JoinHostPort(strings.TrimSuffix(host, "."), port) + *dohPath
+
+	var req *http.Request
+	switch strings.ToUpper(*httpMethod) {
+	case http.MethodGet:
+		// The ID should be zero to make the GET request cacheable, but then
+		// the reply can't be matched with our query, we keep it.
+		req, res.err = http.NewRequest(http.MethodGet, url+"?dns="+base64.RawURLEncoding.EncodeToString(res.query), nil)
+	case http.MethodPost:
+		req, res.err = http.NewRequest(http.MethodPost, url, bytes.NewReader(res.query))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/dns-message")
+		}
+	default:
+		res.err = fmt.Errorf("unknown HTTP method %q", *httpMethod)
+	}
+	if res.err != nil {
+		return res
+	}
+	req.Header.Set("Accept", "application/dns-message")
+	// The addresses for -dnstap come from the connection the request is
+	// sent on, which may be a reused one.
+	var local, remote net.Addr
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			local, remote = info.Conn.LocalAddr(), info.Conn.RemoteAddr()
+		},
+	}))
+
+	t := time.Now()
+	resp, err := dohClient.Do(req)
+	if err != nil {
+		tap.Log(dnstap.ClientQuery, local, remote, dnstap.DoH, res.query, t, nil, time.Time{})
+		res.err = err
+		return res
+	}
+	defer resp.Body.Close()
+	res.reply, res.err = io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	res.rtt = time.Since(t)
+	tap.Log(dnstap.ClientQuery, local, remote, dnstap.DoH, res.query, t, res.reply, t.Add(res.rtt))
+	res.net = "https, " + resp.Proto + " " + req.Method
+	if res.err != nil {
+		return res
+	}
+	if resp.StatusCode != http.StatusOK {
+		res.err = fmt.Errorf("%s returned %s", url, resp.Status)
+		return res
+	}
+	if want := *httpVersion; want != "" && fmt.Sprintf("%d", resp.ProtoMajor) != strings.Split(want, ".")[0] {
+		res.err = fmt.Errorf("server answered with %s instead of HTTP/%s", resp.Proto, want)
+		return res
+	}
+
+	res.r = new(dns.Msg)
+	if res.err = res.r.Unpack(res.reply); res.err != nil {
+		return res
+	}
+	if res.r.Id != m.Id {
+		res.err = dns.ErrId
+		return res
+	}
+	res.err = verifyTsig(c, res.r, res.reply, requestMAC)
+	return res
+}
+
+// newDoHClient returns an HTTP client that only speaks the version given with
+// -http-version. Without it HTTP/2 is tried and HTTP/1.1 is the fallback.
+func newDoHClient(c *dns.Client) (*http.Client, error) {
+	config := c.TLSConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config = config.Clone()
+	tr := &http.Transport{
+		DialContext:       (&net.Dialer{Timeout: c.DialTimeout}).DialContext,
+		TLSClientConfig:   config,
+		ForceAttemptHTTP2: true,
+	}
+	if c.Dialer != nil {
+		tr.DialContext = c.Dialer.DialContext
+	}
+	switch *httpVersion {
+	case "":
+	case "1.1", "1":
+		config.NextProtos = []string{"http/1.1"}
+		tr.ForceAttemptHTTP2 = false
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case "2":
+		config.NextProtos = []string{"h2"}
+	default:
+		return nil, fmt.Errorf("unknown HTTP version %q", *httpVersion)
+	}
+	return &http.Client{Transport: tr, Timeout: c.DialTimeout + c.WriteTimeout + c.ReadTimeout}, nil
+}
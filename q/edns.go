@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// padFlag is the value of -pad. It can be used as a boolean flag, in which
+// case the default block size is used.
+type padFlag struct {
+	block int
+}
+
+// edns0Chain is the option code of the CHAIN option (RFC 7901), the dns
+// package does not know about it.
+const edns0Chain = 13
+
+// defaultPadBlock is the block size recommended for queries in RFC 8467.
+const defaultPadBlock = 128
+
+func (p *padFlag) String() string {
+	if p == nil {
+		return "0"
+	}
+	return strconv.Itoa(p.block)
+}
+
+func (p *padFlag) Set(s string) error {
+	switch s {
+	case "true":
+		p.block = defaultPadBlock
+		return nil
+	case "false":
+		p.block = 0
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > dns.MaxMsgSize {
+		return fmt.Errorf("invalid block size %q", s)
+	}
+	p.block = n
+	return nil
+}
+
+func (p *padFlag) IsBoolFlag() bool { return true }
+
+// padMsg adds an EDNS0 padding option (RFC 7830) to the OPT RR in m, so that
+// the length of m becomes a multiple of block. Any existing padding is
+// replaced.
+func padMsg(m *dns.Msg, block int) {
+	o := m.IsEdns0()
+	if o == nil || block <= 0 {
+		return
+	}
+	e := &dns.EDNS0_PADDING{}
+	for i, opt := range o.Option {
+		if opt.Option() == dns.EDNS0PADDING {
+			o.Option = append(o.Option[:i], o.Option[i+1:]...)
+			break
+		}
+	}
+	o.Option = append(o.Option, e)
+	if l := m.Len() % block; l != 0 {
+		e.Padding = make([]byte, block-l)
+	}
+}
+
+// ednsComments prints a comment line for the EDNS0 options in r that deserve
+// a more readable form than the OPT pseudo section gives them.
+func ednsComments(r *dns.Msg) {
+	o := r.IsEdns0()
+	if o == nil {
+		return
+	}
+	for _, e := range o.Option {
+		switch e := e.(type) {
+		case *dns.EDNS0_NSID:
+			fmt.Fprintf(stdout, ";; NSID: %s (%q)\n", e.Nsid, nsidString(e))
+		case *dns.EDNS0_SUBNET:
+			fmt.Fprintf(stdout, ";; CLIENT-SUBNET: %s/%d, scope /%d\n", e.Address, e.SourceNetmask, e.SourceScope)
+		case *dns.EDNS0_EXPIRE:
+			if !e.Empty {
+				fmt.Fprintf(stdout, ";; EXPIRE: %d seconds (%s)\n", e.Expire, time.Duration(e.Expire)*time.Second)
+			}
+		case *dns.EDNS0_PADDING:
+			fmt.Fprintf(stdout, ";; PADDING: %d bytes\n", len(e.Padding))
+		case *dns.EDNS0_TCP_KEEPALIVE:
+			fmt.Fprintf(stdout, ";; KEEPALIVE: idle timeout %s\n", keepaliveTimeout(e))
+		case *dns.EDNS0_LOCAL:
+			if e.Code == edns0Chain {
+				if name, _, err := dns.UnpackDomainName(e.Data, 0); err == nil {
+					fmt.Fprintf(stdout, ";; CHAIN: closest trust point %s\n", name)
+				}
+			}
+		case *dns.EDNS0_EDE:
+			fmt.Fprintf(stdout, ";; EDE %d (%s)", e.InfoCode, edeString(e.InfoCode))
+			if e.ExtraText != "" {
+				fmt.Fprintf(stdout, ": %s", e.ExtraText)
+			}
+			fmt.Fprintln(stdout)
+		}
+	}
+}
+
+// edeString returns the name of the extended DNS error code (RFC 8914).
+func edeString(code uint16) string {
+	if s, ok := dns.ExtendedErrorCodeToString[code]; ok {
+		return s
+	}
+	return "Unknown"
+}
+
+// keepaliveTimeout returns the idle timeout of the tcp-keepalive option, it is
+// encoded in units of 100 milliseconds.
+func keepaliveTimeout(e *dns.EDNS0_TCP_KEEPALIVE) time.Duration {
+	return time.Duration(e.Timeout) * 100 * time.Millisecond
+}
+
+// chainOption returns a CHAIN option for the closest trust point name.
+func chainOption(name string) (*dns.EDNS0_LOCAL, error) {
+	buf := make([]byte, 255)
+	off, err := dns.PackDomainName(dns.Fqdn(name), buf, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return &dns.EDNS0_LOCAL{Code: edns0Chain, Data: buf[:off]}, nil
+}
+
+// subnetOption returns a client-subnet option (RFC 7871) for s, which is an
+// address or a prefix in CIDR notation. A bare address uses the full length,
+// a /0 prefix asks the server not to use the client's address at all.
+func subnetOption(s string) (*dns.EDNS0_SUBNET, error) {
+	e := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1} // IP4
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		if ip = net.ParseIP(s); ip == nil {
+			return nil, fmt.Errorf("not an address or prefix")
+		}
+		bits := net.IPv4len * 8
+		if ip.To4() == nil {
+			bits = net.IPv6len * 8
+		}
+		ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+	if ip.To4() == nil {
+		e.Family = 2 // IP6
+	}
+	ones, _ := ipnet.Mask.Size()
+	e.SourceNetmask = uint8(ones)
+	e.Address = ipnet.IP
+	return e, nil
+}
+
+// printChain prints the records in r that make up the chain of trust from the
+// closest trust point, these are returned in the authority section.
+func printChain(r *dns.Msg) {
+	var rrs []dns.RR
+	for _, rr := range r.Ns {
+		switch t := rr.(type) {
+		case *dns.DNSKEY, *dns.DS:
+			rrs = append(rrs, rr)
+		case *dns.RRSIG:
+			if t.TypeCovered == dns.TypeDNSKEY || t.TypeCovered == dns.TypeDS {
+				rrs = append(rrs, rr)
+			}
+		}
+	}
+	if len(rrs) == 0 {
+		fmt.Fprintf(stdout, ";; CHAIN: no chain records returned\n")
+		return
+	}
+	fmt.Fprintf(stdout, ";; CHAIN: %d records returned\n", len(rrs))
+	for _, rr := range rrs {
+		fmt.Fprintf(stdout, ";; %s\n", rr)
+	}
+}
+
+// nsidString returns the NSID as a printable string, non printable characters
+// are replaced with a dot.
+func nsidString(e *dns.EDNS0_NSID) string {
+	buf, err := hex.DecodeString(e.Nsid)
+	if err != nil {
+		return ""
+	}
+	for i, c := range buf {
+		if c < 0x20 || c >= 0x7f {
+			buf[i] = '.'
+		}
+	}
+	return string(buf)
+}
+
+// optString returns the OPT pseudo section for o. The NSID and EDE options are
+// left out, they are shown decoded by ednsComments.
+func optString(o *dns.OPT) string {
+	o1 := *o
+	o1.Option = nil
+	for _, e := range o.Option {
+		if c := e.Option(); c != dns.EDNS0NSID && c != dns.EDNS0EDE {
+			o1.Option = append(o1.Option, e)
+		}
+	}
+	return o1.String()
+}
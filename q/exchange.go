@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/miekg/exdns/dnstap"
+)
+
+// result is the outcome of sending a query.
+type result struct {
+	r      *dns.Msg
+	rtt    time.Duration
+	err    error
+	server string
+	net    string // transport used, shown in the footer
+	query  []byte // the query as sent on the wire
+	reply  []byte // the reply as received from the wire
+	timing timing
+	failed []string // errors of the servers tried before, see tryServers
+}
+
+// timing splits the time an exchange took in its parts. Dial and handshake
+// are zero when a connection is reused.
+type timing struct {
+	dial      time.Duration
+	handshake time.Duration // TLS
+	write     time.Duration
+	read      time.Duration // from the end of the write until the reply is read
+}
+
+// String returns the durations that are set, in µs.
+func (t timing) String() string {
+	var s []string
+	for _, d := range []struct {
+		name string
+		d    time.Duration
+	}{{"dial", t.dial}, {"tls", t.handshake}, {"write", t.write}, {"read", t.read}} {
+		if d.d > 0 {
+			s = append(s, fmt.Sprintf("%s %d µs", d.name, d.d.Microseconds()))
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+// queryServers sends m to the servers in order, the first one that answers wins.
+// The errors of the servers that failed are printed.
+func queryServers(c *dns.Client, m *dns.Msg, servers []string) result {
+	res := tryServers(c, m, servers)
+	printFailed(res)
+	return res
+}
+
+// tryServers is queryServers without the printing, the errors are kept in
+// res.failed. It is used from the workers of -parallel, which must not write
+// to stdout.
+func tryServers(c *dns.Client, m *dns.Msg, servers []string) (res result) {
+	var failed []string
+	for _, server := range servers {
+		if res = exchange(c, m, server); res.err == nil {
+			break
+		}
+		if len(servers) > 1 {
+			failed = append(failed, res.server+": "+res.err.Error())
+		}
+	}
+	res.failed = failed
+	return res
+}
+
+// printFailed prints the errors of the servers that failed before res.
+func printFailed(res result) {
+	for _, f := range res.failed {
+		fmt.Fprintf(stdout, ";; %s\n", f)
+	}
+}
+
+// conns holds the connections used in -tcp mode, one per server, so that
+// multiple queries are asked over the same connection.
+var (
+	conns   = map[string]*conn{}
+	connsMu sync.Mutex
+)
+
+// conn is a connection used in -tcp mode. When the server sent an idle
+// timeout in a tcp-keepalive option, the connection is not reused after
+// expire.
+type conn struct {
+	*dns.Conn
+	expire time.Time
+}
+
+// exchange sends m to server and waits for the reply. This does what
+// (*dns.Client).Exchange does, but keeps the wire format of both messages.
+func exchange(c *dns.Client, m *dns.Msg, server string) (res result) {
+	if *httpsf {
+		return exchangeHTTPS(c, m, server)
+	}
+	res.server = server
+	res.net = c.Net
+	var co *dns.Conn
+	if *tcp {
+		connsMu.Lock()
+		defer connsMu.Unlock()
+		if tc := conns[server]; tc != nil {
+			if tc.expire.IsZero() || time.Now().Before(tc.expire) {
+				co = tc.Conn
+			} else {
+				tc.Close()
+				delete(conns, server)
+			}
+		}
+	}
+	if co == nil {
+		var err error
+		if co, err = dial(c, server, &res.timing); err != nil {
+			res.err = fmt.Errorf("dialing %s failed: %s", server, err)
+			return res
+		}
+		if *tcp {
+			conns[server] = &conn{Conn: co}
+		} else {
+			defer co.Close()
+		}
+	}
+	// broken closes a connection that failed, in -tcp mode it is not reused.
+	broken := func() {
+		if *tcp {
+			co.Close()
+			delete(conns, server)
+		}
+	}
+	if opt := m.IsEdns0(); opt != nil && opt.UDPSize() > co.UDPSize {
+		co.UDPSize = opt.UDPSize()
+	}
+
+	var requestMAC string
+	res.query, requestMAC, res.err = packMsg(c, m)
+	if res.err != nil {
+		return res
+	}
+
+	t := time.Now()
+	co.SetWriteDeadline(t.Add(c.WriteTimeout))
+	co.SetReadDeadline(t.Add(c.ReadTimeout))
+	if _, res.err = co.Write(res.query); res.err != nil {
+		broken()
+		return res
+	}
+	res.timing.write = time.Since(t)
+	for {
+		if res.reply, res.err = co.ReadMsgHeader(nil); res.err != nil {
+			tap.Log(dnstap.ClientQuery, co.LocalAddr(), co.RemoteAddr(), tapProto(c.Net), res.query, t, nil, time.Time{})
+			broken()
+			return res
+		}
+		// On UDP ignore replies with mismatched IDs, they might be
+		// responses to earlier queries that timed out.
+		if id := uint16(res.reply[0])<<8 | uint16(res.reply[1]); id == m.Id || strings.HasPrefix(c.Net, "tcp") {
+			break
+		}
+	}
+	res.rtt = time.Since(t)
+	res.timing.read = res.rtt - res.timing.write
+	tap.Log(dnstap.ClientQuery, co.LocalAddr(), co.RemoteAddr(), tapProto(c.Net), res.query, t, res.reply, t.Add(res.rtt))
+
+	res.r = new(dns.Msg)
+	if res.err = res.r.Unpack(res.reply); res.err != nil {
+		broken()
+		return res
+	}
+	if res.r.Id != m.Id {
+		res.err = dns.ErrId
+		broken()
+		return res
+	}
+	if *keepalive {
+		keepaliveConn(server, res.r)
+	}
+	res.err = verifyTsig(c, res.r, res.reply, requestMAC)
+	return res
+}
+
+// verifyTsig verifies the TSIG of r, buf is its wire format and requestMAC the
+// MAC of the query. A reply without TSIG is OK.
+func verifyTsig(c *dns.Client, r *dns.Msg, buf []byte, requestMAC string) error {
+	ts := r.IsTsig()
+	if ts == nil {
+		return nil
+	}
+	if c.TsigProvider != nil {
+		return dns.TsigVerifyWithProvider(buf, c.TsigProvider, requestMAC, false)
+	}
+	secret, ok := c.TsigSecret[ts.Hdr.Name]
+	if !ok {
+		return dns.ErrSecret
+	}
+	return dns.TsigVerify(buf, secret, requestMAC, false)
+}
+
+// dial connects to server like (*dns.Client).Dial, but times the connect and
+// the TLS handshake separately.
+func dial(c *dns.Client, server string, tm *timing) (*dns.Conn, error) {
+	d := net.Dialer{Timeout: c.DialTimeout}
+	if c.Dialer != nil {
+		d = *c.Dialer
+	}
+	network := strings.TrimSuffix(c.Net, "-tls")
+	t := time.Now()
+	co, err := d.Dial(network, server)
+	if err != nil {
+		return nil, err
+	}
+	tm.dial = time.Since(t)
+	if network == c.Net {
+		return &dns.Conn{Conn: co, UDPSize: c.UDPSize}, nil
+	}
+
+	config := &tls.Config{}
+	if c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		host, _, _ := net.SplitHostPort(server)
+		config.ServerName = strings.TrimSuffix(host, ".")
+	}
+	tc := tls.Client(co, config)
+	t = time.Now()
+	tc.SetDeadline(t.Add(c.DialTimeout))
+	if err := tc.Handshake(); err != nil {
+		co.Close()
+		return nil, err
+	}
+	tc.SetDeadline(time.Time{})
+	tm.handshake = time.Since(t)
+	return &dns.Conn{Conn: tc, UDPSize: c.UDPSize}, nil
+}
+
+// keepaliveConn sets the expiry of the connection to server from the
+// tcp-keepalive option in r. A timeout of zero means the server wants the
+// connection closed.
+func keepaliveConn(server string, r *dns.Msg) {
+	o := r.IsEdns0()
+	if o == nil {
+		return
+	}
+	for _, e := range o.Option {
+		if k, ok := e.(*dns.EDNS0_TCP_KEEPALIVE); ok {
+			tc := conns[server]
+			if k.Timeout == 0 {
+				tc.Close()
+				delete(conns, server)
+				return
+			}
+			tc.expire = time.Now().Add(keepaliveTimeout(k))
+			return
+		}
+	}
+}
+
+// rawQuery holds the query read with -raw-in, it is sent as-is.
+var rawQuery []byte
+
+// packMsg returns the wire format of m, signed when it carries a TSIG RR. The
+// MAC of the signature is returned as well.
+func packMsg(c *dns.Client, m *dns.Msg) ([]byte, string, error) {
+	if rawQuery != nil {
+		return rawQuery, "", nil
+	}
+	ts := m.IsTsig()
+	if ts == nil {
+		buf, err := m.Pack()
+		return buf, "", err
+	}
+	if c.TsigProvider != nil {
+		return dns.TsigGenerateWithProvider(m, c.TsigProvider, "", false)
+	}
+	secret, ok := c.TsigSecret[ts.Hdr.Name]
+	if !ok {
+		return nil, "", dns.ErrSecret
+	}
+	return dns.TsigGenerate(m, secret, "", false)
+}
+
+// readRaw reads a wire format message from file, the message is used as the
+// query and its bytes are sent unaltered.
+func readRaw(file string) (*dns.Msg, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		return nil, err
+	}
+	if len(m.Question) == 0 {
+		return nil, fmt.Errorf("no question section")
+	}
+	rawQuery = buf
+	return m, nil
+}
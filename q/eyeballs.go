@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// connectionAttemptDelay is the delay between connection attempts, RFC 8305
+// Section 5 recommends 250 ms.
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// happyEyeballs resolves the name in server and when it has both IPv4 and IPv6
+// addresses, races them as RFC 8305 describes: the addresses are interleaved,
+// IPv6 first, and a new attempt is started every connectionAttemptDelay until
+// one succeeds. For TCP an attempt is a connect, for UDP it is a query for the
+// root NS records. The address that won is returned and printed.
+func happyEyeballs(c *dns.Client, server string) string {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil || net.ParseIP(host) != nil {
+		return server
+	}
+	ips, err := net.LookupIP(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return server
+	}
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	if *four {
+		v6 = nil
+	}
+	if *six {
+		v4 = nil
+	}
+	var addrs []string
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			addrs = append(addrs, net.JoinHostPort(v6[i].String(), port))
+		}
+		if i < len(v4) {
+			addrs = append(addrs, net.JoinHostPort(v4[i].String(), port))
+		}
+	}
+	if len(addrs) == 0 {
+		return server
+	}
+	if len(v4) == 0 || len(v6) == 0 {
+		return addrs[0]
+	}
+
+	type attempt struct {
+		addr string
+		err  error
+	}
+	done := make(chan attempt, len(addrs))
+	try := func(addr string) {
+		if strings.HasPrefix(c.Net, "tcp") {
+			// The same dialer as the query, so -laddr, -tos and -mark apply.
+			d := net.Dialer{Timeout: c.DialTimeout}
+			if c.Dialer != nil {
+				d = *c.Dialer
+			}
+			co, err := d.Dial("tcp", addr)
+			if err == nil {
+				co.Close()
+			}
+			done <- attempt{addr, err}
+			return
+		}
+		m := new(dns.Msg)
+		m.SetQuestion(".", dns.TypeNS)
+		_, _, err := c.Exchange(m, addr)
+		done <- attempt{addr, err}
+	}
+
+	started, failed := 0, 0
+	for failed < len(addrs) {
+		if started < len(addrs) {
+			go try(addrs[started])
+			started++
+		}
+		var timeout <-chan time.Time
+		if started < len(addrs) {
+			timeout = time.After(connectionAttemptDelay)
+		}
+		select {
+		case a := <-done:
+			if a.err != nil {
+				failed++
+				continue
+			}
+			family := "IPv4"
+			if strings.HasPrefix(a.addr, "[") {
+				family = "IPv6"
+			}
+			fmt.Fprintf(stdout, ";; %s: %s won (%s)\n", host, a.addr, family)
+			return a.addr
+		case <-timeout:
+		}
+	}
+	return addrs[0]
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// GSS-TSIG (RFC 3645): a key is negotiated with the server in TKEY queries
+// that carry GSS-API (Kerberos) tokens, the messages are then signed with the
+// security context instead of a shared secret. This is what Active Directory
+// DNS servers want for dynamic updates.
+//
+// The GSS-API library is only used when q is built with -tags gssapi, which
+// needs cgo and the MIT Kerberos headers. The credentials come from kinit.
+
+// gssTsig is the algorithm name of GSS-TSIG.
+const gssTsig = "gss-tsig."
+
+// gssContext is a GSS-API security context that is being established.
+type gssContext interface {
+	// step processes the token from the server, nil for the first call, and
+	// returns the token to send. Done is true when the context is established.
+	step(in []byte) (out []byte, done bool, err error)
+	// mic returns the message integrity code of msg.
+	mic(msg []byte) ([]byte, error)
+	// verifyMIC checks mic is the message integrity code of msg.
+	verifyMIC(msg, mic []byte) error
+}
+
+// newGSSContext returns a security context for the service, e.g.
+// DNS@ns.example.org. It is set when q is built with -tags gssapi.
+var newGSSContext func(service string) (gssContext, error)
+
+// gssProvider signs and verifies messages with an established context.
+type gssProvider struct{ ctx gssContext }
+
+func (p gssProvider) Generate(msg []byte, _ *dns.TSIG) ([]byte, error) { return p.ctx.mic(msg) }
+
+func (p gssProvider) Verify(msg []byte, t *dns.TSIG) error {
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	if err := p.ctx.verifyMIC(msg, mac); err != nil {
+		return dns.ErrSig
+	}
+	return nil
+}
+
+// gssHost returns the host name of the server for GSS-TSIG, from -tsig
+// gss-tsig:host or else from server. Kerberos needs a name, not an address.
+func gssHost(s, server string) (string, error) {
+	if _, host, ok := strings.Cut(s, ":"); ok {
+		return strings.TrimSuffix(host, "."), nil
+	}
+	host, _, _ := net.SplitHostPort(server)
+	if net.ParseIP(host) != nil {
+		return "", fmt.Errorf("%s is an address, give the server name with -tsig gss-tsig:name", host)
+	}
+	return strings.TrimSuffix(host, "."), nil
+}
+
+// gssNegotiate establishes a GSS-TSIG key with server over TCP, host is the
+// host name of the server. It returns the name of the key and the provider
+// that signs with it.
+func gssNegotiate(c *dns.Client, server, host string) (string, dns.TsigProvider, error) {
+	if newGSSContext == nil {
+		return "", nil, fmt.Errorf("q is built without GSS-API support, build it with -tags gssapi")
+	}
+	ctx, err := newGSSContext("DNS@" + host)
+	if err != nil {
+		return "", nil, err
+	}
+	tc := *c
+	tc.Net = strings.Replace(strings.TrimSuffix(c.Net, "-tls"), "udp", "tcp", 1)
+	co, err := dial(&tc, server, &timing{})
+	if err != nil {
+		return "", nil, err
+	}
+	defer co.Close()
+
+	key := fmt.Sprintf("%d.q.", rand.Uint32())
+	var (
+		r    *dns.Msg
+		buf  []byte
+		in   []byte
+		out  []byte
+		done bool
+		tkey *dns.TKEY
+	)
+	out, done, err = ctx.step(nil)
+	for {
+		if err != nil {
+			return "", nil, err
+		}
+		if len(out) == 0 {
+			if !done {
+				return "", nil, fmt.Errorf("GSS-API returned no token to send")
+			}
+			return key, gssProvider{ctx}, nil
+		}
+		if r, buf, err = tkeyExchange(co, &tc, key, out); err != nil {
+			return "", nil, err
+		}
+		tkey = nil
+		for _, rr := range r.Answer {
+			if t, ok := rr.(*dns.TKEY); ok && strings.EqualFold(t.Hdr.Name, key) {
+				tkey = t
+			}
+		}
+		if tkey == nil {
+			return "", nil, fmt.Errorf("no TKEY in the reply")
+		}
+		if tkey.Error != dns.RcodeSuccess {
+			return "", nil, fmt.Errorf("TKEY error %s", dns.RcodeToString[int(tkey.Error)])
+		}
+		if !done {
+			if in, err = hex.DecodeString(tkey.Key); err != nil {
+				return "", nil, err
+			}
+			if out, done, err = ctx.step(in); err != nil || !done || len(out) > 0 {
+				continue
+			}
+		}
+		// The reply that completes the negotiation is signed with the new key.
+		if r.IsTsig() != nil {
+			if err := dns.TsigVerifyWithProvider(buf, gssProvider{ctx}, "", false); err != nil {
+				return "", nil, fmt.Errorf("TKEY reply: %s", err)
+			}
+		}
+		return key, gssProvider{ctx}, nil
+	}
+}
+
+// tkeyExchange sends a TKEY query in GSS-API negotiation mode with token to
+// co and returns the reply and its wire format.
+func tkeyExchange(co *dns.Conn, c *dns.Client, key string, token []byte) (*dns.Msg, []byte, error) {
+	now := uint32(time.Now().Unix())
+	m := new(dns.Msg)
+	m.SetQuestion(key, dns.TypeTKEY)
+	m.Question[0].Qclass = dns.ClassANY
+	m.RecursionDesired = false
+	m.Extra = []dns.RR{&dns.TKEY{
+		Hdr:        dns.RR_Header{Name: key, Rrtype: dns.TypeTKEY, Class: dns.ClassANY},
+		Algorithm:  gssTsig,
+		Inception:  now,
+		Expiration: now + 86400,
+		Mode:       3, // GSS-API negotiation, RFC 2930
+		KeySize:    uint16(len(token)),
+		Key:        hex.EncodeToString(token),
+	}}
+	co.SetDeadline(time.Now().Add(c.ReadTimeout + c.WriteTimeout))
+	if err := co.WriteMsg(m); err != nil {
+		return nil, nil, err
+	}
+	buf, err := co.ReadMsgHeader(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(buf); err != nil {
+		return nil, nil, err
+	}
+	if r.Id != m.Id {
+		return nil, nil, dns.ErrId
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, nil, fmt.Errorf("TKEY query: %s", dns.RcodeToString[r.Rcode])
+	}
+	return r, buf, nil
+}
@@ -0,0 +1,130 @@
+//go:build gssapi && cgo
+
+package main
+
+/*
+#cgo LDFLAGS: -lgssapi_krb5
+#include <stdlib.h>
+#include <string.h>
+#include <gssapi/gssapi.h>
+
+// SPNEGO, which is what Active Directory and BIND use for GSS-TSIG.
+static gss_OID_desc spnego = {6, (void *)"\x2b\x06\x01\x05\x05\x02"};
+
+static int q_error(OM_uint32 major) { return GSS_ERROR(major) != 0; }
+
+static OM_uint32 q_import_name(OM_uint32 *minor, char *service, gss_name_t *name) {
+	gss_buffer_desc buf = {strlen(service), service};
+	return gss_import_name(minor, &buf, GSS_C_NT_HOSTBASED_SERVICE, name);
+}
+
+static OM_uint32 q_init(OM_uint32 *minor, gss_ctx_id_t *ctx, gss_name_t name, void *in, size_t inlen, gss_buffer_desc *out, int *cont) {
+	gss_buffer_desc inb = {inlen, in};
+	OM_uint32 major = gss_init_sec_context(minor, GSS_C_NO_CREDENTIAL, ctx, name, &spnego,
+		GSS_C_REPLAY_FLAG | GSS_C_MUTUAL_FLAG | GSS_C_INTEG_FLAG, 0, GSS_C_NO_CHANNEL_BINDINGS,
+		inlen > 0 ? &inb : GSS_C_NO_BUFFER, NULL, out, NULL, NULL);
+	*cont = (major & GSS_S_CONTINUE_NEEDED) != 0;
+	return major;
+}
+
+static OM_uint32 q_get_mic(OM_uint32 *minor, gss_ctx_id_t ctx, void *msg, size_t len, gss_buffer_desc *out) {
+	gss_buffer_desc m = {len, msg};
+	return gss_get_mic(minor, ctx, GSS_C_QOP_DEFAULT, &m, out);
+}
+
+static OM_uint32 q_verify_mic(OM_uint32 *minor, gss_ctx_id_t ctx, void *msg, size_t len, void *mic, size_t miclen) {
+	gss_buffer_desc m = {len, msg}, t = {miclen, mic};
+	return gss_verify_mic(minor, ctx, &m, &t, NULL);
+}
+
+static void q_release(gss_buffer_desc *buf) {
+	OM_uint32 minor;
+	gss_release_buffer(&minor, buf);
+}
+
+static void q_status(OM_uint32 code, int type, gss_buffer_desc *out) {
+	OM_uint32 minor, more = 0;
+	gss_display_status(&minor, code, type, GSS_C_NO_OID, &more, out);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+func init() { newGSSContext = newGSSAPI }
+
+// gssapi is a security context of the system's GSS-API library, the
+// credentials come from the Kerberos ticket cache (kinit).
+type gssapi struct {
+	name C.gss_name_t
+	ctx  C.gss_ctx_id_t
+}
+
+func newGSSAPI(service string) (gssContext, error) {
+	cs := C.CString(service)
+	defer C.free(unsafe.Pointer(cs))
+	g := new(gssapi)
+	var minor C.OM_uint32
+	if major := C.q_import_name(&minor, cs, &g.name); C.q_error(major) != 0 {
+		return nil, gssError(major, minor)
+	}
+	return g, nil
+}
+
+func (g *gssapi) step(in []byte) ([]byte, bool, error) {
+	var (
+		minor C.OM_uint32
+		out   C.gss_buffer_desc
+		cont  C.int
+	)
+	major := C.q_init(&minor, &g.ctx, g.name, bytesPtr(in), C.size_t(len(in)), &out, &cont)
+	defer C.q_release(&out)
+	if C.q_error(major) != 0 {
+		return nil, false, gssError(major, minor)
+	}
+	return C.GoBytes(out.value, C.int(out.length)), cont == 0, nil
+}
+
+func (g *gssapi) mic(msg []byte) ([]byte, error) {
+	var (
+		minor C.OM_uint32
+		out   C.gss_buffer_desc
+	)
+	major := C.q_get_mic(&minor, g.ctx, bytesPtr(msg), C.size_t(len(msg)), &out)
+	defer C.q_release(&out)
+	if C.q_error(major) != 0 {
+		return nil, gssError(major, minor)
+	}
+	return C.GoBytes(out.value, C.int(out.length)), nil
+}
+
+func (g *gssapi) verifyMIC(msg, mic []byte) error {
+	var minor C.OM_uint32
+	major := C.q_verify_mic(&minor, g.ctx, bytesPtr(msg), C.size_t(len(msg)), bytesPtr(mic), C.size_t(len(mic)))
+	if C.q_error(major) != 0 {
+		return gssError(major, minor)
+	}
+	return nil
+}
+
+// bytesPtr returns a pointer to the first byte of b, or nil.
+func bytesPtr(b []byte) unsafe.Pointer {
+	if len(b) == 0 {
+		return nil
+	}
+	return unsafe.Pointer(&b[0])
+}
+
+// gssError returns the messages of the major and minor status codes.
+func gssError(major, minor C.OM_uint32) error {
+	status := func(code C.OM_uint32, typ C.int) string {
+		var buf C.gss_buffer_desc
+		C.q_status(code, typ, &buf)
+		defer C.q_release(&buf)
+		return C.GoStringN((*C.char)(buf.value), C.int(buf.length))
+	}
+	return fmt.Errorf("GSS-API: %s: %s", status(major, C.GSS_C_GSS_CODE), status(minor, C.GSS_C_MECH_CODE))
+}
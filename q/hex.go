@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// hexdump prints buf, a DNS message in wire format, as a hexdump followed by
+// annotations for the header fields, and the owner names, types and lengths
+// of the question and resource records, including where name compression
+// pointers point to.
+func hexdump(title string, buf []byte) {
+	fmt.Fprintf(stdout, ";; %s (%d bytes)\n", title, len(buf))
+	for off := 0; off < len(buf); off += 16 {
+		end := off + 16
+		if end > len(buf) {
+			end = len(buf)
+		}
+		var hex, ascii strings.Builder
+		for i := off; i < off+16; i++ {
+			if i == off+8 {
+				hex.WriteByte(' ')
+			}
+			if i >= end {
+				hex.WriteString("   ")
+				continue
+			}
+			fmt.Fprintf(&hex, " %02x", buf[i])
+			if buf[i] >= 0x20 && buf[i] < 0x7f {
+				ascii.WriteByte(buf[i])
+			} else {
+				ascii.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(stdout, ";; %04x %s  |%s|\n", off, hex.String(), ascii.String())
+	}
+	fmt.Fprintln(stdout, ";;")
+	for _, a := range annotate(buf) {
+		fmt.Fprintf(stdout, ";; %s\n", a)
+	}
+	fmt.Fprintln(stdout)
+}
+
+// annotate walks the message in buf and returns a description for each of
+// the fields it finds, prefixed with the offset of the field.
+func annotate(buf []byte) (notes []string) {
+	note := func(off int, format string, a ...interface{}) {
+		notes = append(notes, fmt.Sprintf("%04x  ", off)+fmt.Sprintf(format, a...))
+	}
+	if len(buf) < 12 {
+		note(0, "short message, no complete header")
+		return notes
+	}
+	flags := binary.BigEndian.Uint16(buf[2:])
+	note(0, "id: %d", binary.BigEndian.Uint16(buf))
+	note(2, "flags: 0x%04x, qr: %d, opcode: %s, aa: %d, tc: %d, rd: %d, ra: %d, z: %d, ad: %d, cd: %d, rcode: %s",
+		flags, flags>>15, dns.OpcodeToString[int(flags>>11&0xF)], flags>>10&1, flags>>9&1, flags>>8&1,
+		flags>>7&1, flags>>6&1, flags>>5&1, flags>>4&1, dns.RcodeToString[int(flags&0xF)])
+	var count [4]int
+	for i, name := range []string{"qdcount", "ancount", "nscount", "arcount"} {
+		count[i] = int(binary.BigEndian.Uint16(buf[4+2*i:]))
+		note(4+2*i, "%s: %d", name, count[i])
+	}
+
+	off := 12
+	for i := 0; i < count[0]; i++ {
+		name, end, ptr, err := wireName(buf, off)
+		if err != nil || end+4 > len(buf) {
+			note(off, "question: malformed")
+			return notes
+		}
+		note(off, "question: %s%s", name, pointerNote(buf, ptr))
+		note(end, "qtype: %s, qclass: %s", typeString(binary.BigEndian.Uint16(buf[end:])), classString(binary.BigEndian.Uint16(buf[end+2:])))
+		off = end + 4
+	}
+	for s, section := range []string{"answer", "authority", "additional"} {
+		for i := 0; i < count[s+1]; i++ {
+			name, end, ptr, err := wireName(buf, off)
+			if err != nil || end+10 > len(buf) {
+				note(off, "%s: malformed", section)
+				return notes
+			}
+			note(off, "%s: %s%s", section, name, pointerNote(buf, ptr))
+			rrtype := binary.BigEndian.Uint16(buf[end:])
+			rdlength := int(binary.BigEndian.Uint16(buf[end+8:]))
+			if rrtype == dns.TypeOPT {
+				note(end, "type: OPT, udp size: %d, ttl: 0x%08x", binary.BigEndian.Uint16(buf[end+2:]), binary.BigEndian.Uint32(buf[end+4:]))
+			} else {
+				note(end, "type: %s, class: %s, ttl: %d", typeString(rrtype), classString(binary.BigEndian.Uint16(buf[end+2:])), binary.BigEndian.Uint32(buf[end+4:]))
+			}
+			note(end+8, "rdlength: %d", rdlength)
+			off = end + 10 + rdlength
+			if off > len(buf) {
+				note(end+10, "%s: rdata overflows message", section)
+				return notes
+			}
+		}
+	}
+	if off < len(buf) {
+		note(off, "%d trailing bytes", len(buf)-off)
+	}
+	return notes
+}
+
+// wireName reads the domain name at off in buf. It returns the name, the
+// offset just after the name and the offset of the first compression pointer
+// that was followed, or -1 if the name was not compressed.
+func wireName(buf []byte, off int) (name string, end int, ptr int, err error) {
+	ptr, end = -1, -1
+	var labels []string
+	for hops := 0; hops < 128; hops++ {
+		if off >= len(buf) {
+			return "", 0, 0, dns.ErrBuf
+		}
+		c := int(buf[off])
+		switch c & 0xC0 {
+		case 0x00:
+			if c == 0 {
+				if end < 0 {
+					end = off + 1
+				}
+				return dns.Fqdn(strings.Join(labels, ".")), end, ptr, nil
+			}
+			if off+1+c > len(buf) {
+				return "", 0, 0, dns.ErrBuf
+			}
+			labels = append(labels, string(buf[off+1:off+1+c]))
+			off += 1 + c
+		case 0xC0:
+			if off+1 >= len(buf) {
+				return "", 0, 0, dns.ErrBuf
+			}
+			if end < 0 {
+				end = off + 2
+				ptr = off
+			}
+			off = (c&0x3F)<<8 | int(buf[off+1])
+		default:
+			return "", 0, 0, dns.ErrRdata
+		}
+	}
+	return "", 0, 0, dns.ErrLongDomain
+}
+
+// pointerNote describes the compression pointer at ptr, if any.
+func pointerNote(buf []byte, ptr int) string {
+	if ptr < 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (compression pointer at %04x to %04x)", ptr, int(buf[ptr]&0x3F)<<8|int(buf[ptr+1]))
+}
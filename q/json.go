@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// jsonMsg is the JSON representation of a response as printed with -json.
+type jsonMsg struct {
+	Server     string         `json:"server"`
+	Net        string         `json:"net"`
+	Time       int64          `json:"time_us"`
+	Size       int            `json:"size"`
+	Header     jsonHeader     `json:"header"`
+	Question   []jsonQuestion `json:"question"`
+	Answer     []jsonRR       `json:"answer"`
+	Authority  []jsonRR       `json:"authority"`
+	Additional []jsonRR       `json:"additional"`
+	Edns       *jsonEdns      `json:"edns,omitempty"`
+}
+
+type jsonHeader struct {
+	Id     uint16          `json:"id"`
+	Opcode string          `json:"opcode"`
+	Rcode  string          `json:"rcode"`
+	Flags  map[string]bool `json:"flags"`
+}
+
+type jsonQuestion struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+}
+
+type jsonRR struct {
+	Name  string `json:"name"`
+	TTL   uint32 `json:"ttl"`
+	Class string `json:"class"`
+	Type  string `json:"type"`
+	Rdata string `json:"rdata"`
+}
+
+type jsonEdns struct {
+	Version uint8        `json:"version"`
+	Do      bool         `json:"do"`
+	UDPSize uint16       `json:"udp_size"`
+	Options []jsonOption `json:"options,omitempty"`
+}
+
+type jsonOption struct {
+	Code uint16 `json:"code"`
+	Data string `json:"data"`
+}
+
+// printJSON prints r as a single JSON object on one line.
+func printJSON(r *dns.Msg, rtt time.Duration, server, net string) {
+	j := jsonMsg{
+		Server: server,
+		Net:    net,
+		Time:   rtt.Microseconds(),
+		Size:   r.Len(),
+		Header: jsonHeader{
+			Id:     r.Id,
+			Opcode: dns.OpcodeToString[r.Opcode],
+			Rcode:  dns.RcodeToString[r.Rcode],
+			Flags: map[string]bool{
+				"qr": r.Response,
+				"aa": r.Authoritative,
+				"tc": r.Truncated,
+				"rd": r.RecursionDesired,
+				"ra": r.RecursionAvailable,
+				"z":  r.Zero,
+				"ad": r.AuthenticatedData,
+				"cd": r.CheckingDisabled,
+			},
+		},
+		Question:   []jsonQuestion{},
+		Answer:     jsonSection(r.Answer),
+		Authority:  jsonSection(r.Ns),
+		Additional: jsonSection(r.Extra),
+	}
+	for _, q := range r.Question {
+		j.Question = append(j.Question, jsonQuestion{Name: q.Name, Type: typeString(q.Qtype), Class: classString(q.Qclass)})
+	}
+	if o := r.IsEdns0(); o != nil {
+		j.Edns = &jsonEdns{Version: o.Version(), Do: o.Do(), UDPSize: o.UDPSize()}
+		for _, e := range o.Option {
+			j.Edns.Options = append(j.Edns.Options, jsonOption{Code: e.Option(), Data: e.String()})
+		}
+	}
+	buf, err := json.Marshal(j)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, ";; %s\n", err)
+		return
+	}
+	fmt.Fprintf(stdout, "%s\n", buf)
+}
+
+// jsonSection converts the RRs in a section, the OPT RR is skipped as it is
+// shown separately.
+func jsonSection(section []dns.RR) []jsonRR {
+	rrs := []jsonRR{}
+	for _, rr := range section {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeOPT {
+			continue
+		}
+		rrs = append(rrs, jsonRR{
+			Name:  h.Name,
+			TTL:   h.Ttl,
+			Class: classString(h.Class),
+			Type:  typeString(h.Rrtype),
+			Rdata: strings.TrimPrefix(rr.String(), h.String()),
+		})
+	}
+	return rrs
+}
+
+func typeString(t uint16) string {
+	if s, ok := dns.TypeToString[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+func classString(c uint16) string {
+	if s, ok := dns.ClassToString[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("CLASS%d", c)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// The LLMNR multicast addresses, RFC 4795 Section 2.
+var (
+	llmnrIPv4 = net.IPv4(224, 0, 0, 252)
+	llmnrIPv6 = net.ParseIP("ff02::1:3")
+)
+
+// llmnrQuery sends the questions with LLMNR (RFC 4795). Without servers the
+// query is multicast and all responses that arrive within the read timeout are
+// shown, as more than one host may answer. In LLMNR the AA bit is the C
+// (conflict) bit and the RD bit is the T (tentative) bit, RD is never set.
+// The IPv6 multicast address is link-local, the link is given with -interface.
+func llmnrQuery(question []dns.Question, servers []string, timeout time.Duration) {
+	if len(servers) == 0 {
+		host := llmnrIPv4.String()
+		if *six {
+			if *iface == "" {
+				fmt.Fprintf(stdout, ";; IPv6 LLMNR multicast needs the interface, use -interface\n")
+				return
+			}
+			if _, err := net.InterfaceByName(*iface); err != nil {
+				fmt.Fprintf(stdout, ";; %s: %s\n", *iface, err.Error())
+				return
+			}
+			host = llmnrIPv6.String() + "%" + *iface
+		}
+		servers = []string{net.JoinHostPort(host, strconv.Itoa(*port))}
+	}
+	network := "udp"
+	if *four {
+		network = "udp4"
+	}
+	if *six {
+		network = "udp6"
+	}
+
+	for _, q := range question {
+		for _, server := range servers {
+			addr, err := net.ResolveUDPAddr(network, server)
+			if err != nil {
+				fmt.Fprintf(stdout, ";; %s: %s\n", server, err.Error())
+				continue
+			}
+			llmnrExchange(network, q, addr, timeout)
+		}
+	}
+}
+
+func llmnrExchange(network string, q dns.Question, addr *net.UDPAddr, timeout time.Duration) {
+	co, err := net.ListenUDP(network, nil)
+	if err != nil {
+		fmt.Fprintf(stdout, ";; %s\n", err.Error())
+		return
+	}
+	defer co.Close()
+
+	m := new(dns.Msg)
+	m.Id = dns.Id()
+	m.Question = []dns.Question{q}
+	buf, err := m.Pack()
+	if err != nil {
+		fmt.Fprintf(stdout, ";; %s\n", err.Error())
+		return
+	}
+	t := time.Now()
+	if _, err := co.WriteToUDP(buf, addr); err != nil {
+		fmt.Fprintf(stdout, ";; %s: %s\n", addr, err.Error())
+		return
+	}
+
+	co.SetReadDeadline(t.Add(timeout))
+	replies := 0
+	b := make([]byte, dns.MaxMsgSize)
+	for {
+		n, from, err := co.ReadFromUDP(b)
+		if err != nil {
+			break
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack(b[:n]); err != nil || r.Id != m.Id || !r.Response {
+			continue
+		}
+		replies++
+		flags := ""
+		if r.Authoritative {
+			flags += " C"
+		}
+		if r.Truncated {
+			flags += " TC"
+		}
+		if r.RecursionDesired {
+			flags += " T"
+		}
+		if flags == "" {
+			flags = " none"
+		}
+		fmt.Fprintf(stdout, ";; LLMNR reply from %s, flags:%s\n", from, flags)
+		printMsg(result{r: r, rtt: time.Since(t), server: from.String(), net: network})
+		if !addr.IP.IsMulticast() {
+			break
+		}
+	}
+	if replies == 0 {
+		fmt.Fprintf(stdout, ";; no LLMNR replies for %s from %s\n", q.Name, addr)
+	}
+}
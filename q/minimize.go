@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// minimize sends the queries a QNAME minimizing resolver (RFC 9156) would
+// send before asking for the full name in m: one label is added at a time and
+// type A is used for the intermediate names. An NXDOMAIN for an intermediate
+// name stops the walk, as the resolver would (RFC 8020), this shows servers
+// that get empty non-terminals wrong.
+func minimize(c *dns.Client, m *dns.Msg, servers []string) {
+	q := m.Question[0]
+	labels := dns.SplitDomainName(q.Name)
+	for i := len(labels) - 1; i > 0; i-- {
+		name := dns.Fqdn(strings.Join(labels[i:], "."))
+		mm := m.Copy()
+		mm.Id = dns.Id()
+		mm.Question[0] = dns.Question{Name: name, Qtype: dns.TypeA, Qclass: q.Qclass}
+		res := queryServers(c, mm, servers)
+		if res.err != nil {
+			fmt.Fprintf(stdout, ";; minimize: %s A: %s\n", name, res.err.Error())
+			return
+		}
+		fmt.Fprintf(stdout, ";; minimize: %s A -> %s (answer %d, authority %d) from %s\n",
+			name, dns.RcodeToString[res.r.Rcode], len(res.r.Answer), len(res.r.Ns), res.server)
+		if res.r.Rcode == dns.RcodeNameError {
+			fmt.Fprintf(stdout, ";- NXDOMAIN for %s, a minimizing resolver does not look up %s\n", name, q.Name)
+			return
+		}
+	}
+	fmt.Fprintf(stdout, ";; minimize: %s %s\n\n", q.Name, typeString(q.Qtype))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/miekg/dns"
+)
+
+// queryParallel sends the msgs with at most n queries in flight. The result
+// for msgs[i] is delivered on the i-th channel, so the caller can print them
+// in order. Zone transfers are skipped, these are handled by the caller.
+func queryParallel(c *dns.Client, msgs []*dns.Msg, servers []string, n int) []chan result {
+	prefetch := make([]chan result, len(msgs))
+	for i := range prefetch {
+		prefetch[i] = make(chan result, 1)
+	}
+	work := make(chan int)
+	for j := 0; j < n; j++ {
+		go func() {
+			for i := range work {
+				prefetch[i] <- tryServers(c, msgs[i], servers)
+			}
+		}()
+	}
+	go func() {
+		for i, m := range msgs {
+			if qt := m.Question[0].Qtype; qt == dns.TypeAXFR || qt == dns.TypeIXFR {
+				continue
+			}
+			work <- i
+		}
+		close(work)
+	}()
+	return prefetch
+}
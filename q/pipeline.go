@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/miekg/exdns/dnstap"
+)
+
+// queryPipelined sends all msgs on one TCP connection to server without
+// waiting for the replies, as allowed by RFC 7766, and matches the replies
+// by ID as they come in. Queries with the same ID get a new one, so each
+// reply matches one query. The result for msgs[i] is delivered on the i-th
+// channel. The order in which the replies arrived is printed.
+func queryPipelined(c *dns.Client, msgs []*dns.Msg, server string) []chan result {
+	results := make([]chan result, len(msgs))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	fail := func(err error) []chan result {
+		for i := range results {
+			results[i] <- result{err: err, server: server}
+		}
+		return results
+	}
+
+	co, err := c.Dial(server)
+	if err != nil {
+		return fail(fmt.Errorf("dialing %s failed: %s", server, err))
+	}
+	defer co.Close()
+
+	ids := map[uint16]int{}
+	sent := make([]time.Time, len(msgs))
+	queries := make([][]byte, len(msgs))
+	macs := make([]string, len(msgs))
+	co.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
+	for i, m := range msgs {
+		if qt := m.Question[0].Qtype; qt == dns.TypeAXFR || qt == dns.TypeIXFR {
+			continue
+		}
+		// The replies are matched by ID, it must be unique.
+		for _, dup := ids[m.Id]; dup; _, dup = ids[m.Id] {
+			m.Id = dns.Id()
+			if ts := m.IsTsig(); ts != nil {
+				ts.OrigId = m.Id
+			}
+		}
+		if queries[i], macs[i], err = packMsg(c, m); err != nil {
+			return fail(err)
+		}
+		ids[m.Id] = i
+		sent[i] = time.Now()
+		if _, err := co.Write(queries[i]); err != nil {
+			return fail(err)
+		}
+	}
+
+	var order []string
+	co.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+	for len(ids) > 0 {
+		buf, err := co.ReadMsgHeader(nil)
+		if err != nil {
+			for _, i := range ids {
+				tap.Log(dnstap.ClientQuery, co.LocalAddr(), co.RemoteAddr(), tapProto(c.Net), queries[i], sent[i], nil, time.Time{})
+				results[i] <- result{err: err, server: server}
+			}
+			break
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack(buf); err != nil {
+			continue
+		}
+		i, ok := ids[r.Id]
+		if !ok {
+			continue
+		}
+		delete(ids, r.Id)
+		tap.Log(dnstap.ClientQuery, co.LocalAddr(), co.RemoteAddr(), tapProto(c.Net), queries[i], sent[i], buf, time.Now())
+		order = append(order, strconv.Itoa(i+1))
+		res := result{r: r, rtt: time.Since(sent[i]), server: server, net: c.Net, query: queries[i], reply: buf}
+		res.err = verifyTsig(c, r, buf, macs[i])
+		results[i] <- res
+	}
+	fmt.Fprintf(stdout, ";; pipelined %d queries to %s, replies in order: %s\n\n", len(msgs), server, strings.Join(order, " "))
+	return results
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// printMsg prints the response in res and a footer with the query time, its
+// breakdown, the server and size.
+func printMsg(res result) {
+	r := res.r
+	if *jsonf {
+		printJSON(r, res.rtt, res.server, res.net)
+		return
+	}
+	printText(r)
+	if *nocomments {
+		return
+	}
+	ednsComments(r)
+	printDS(r)
+	if *chain != "" {
+		printChain(r)
+	}
+	fmt.Fprintf(stdout, "\n;; query time: %.3d µs", res.rtt/1e3)
+	if t := res.timing.String(); t != "" {
+		fmt.Fprintf(stdout, " (%s)", t)
+	}
+	fmt.Fprintf(stdout, ", server: %s(%s), size: %d bytes\n", res.server, res.net, r.Len())
+}
+
+// printText prints r in presentation format, like (*dns.Msg).String, but
+// only shows the sections selected with the display flags.
+func printText(r *dns.Msg) {
+	names := [...]string{"QUESTION", "ANSWER", "AUTHORITY", "ADDITIONAL"}
+	counts := [...]string{"QUERY", "ANSWER", "AUTHORITY", "ADDITIONAL"}
+	if r.Opcode == dns.OpcodeUpdate {
+		names = [...]string{"ZONE", "PREREQUISITE", "UPDATE", "ADDITIONAL"}
+		counts = [...]string{"ZONE", "PREREQ", "UPDATE", "ADDITIONAL"}
+	}
+	if !*nocomments {
+		fmt.Fprintf(stdout, "%s %s: %d, %s: %d, %s: %d, %s: %d\n", &r.MsgHdr,
+			counts[0], len(r.Question), counts[1], len(r.Answer), counts[2], len(r.Ns), counts[3], len(r.Extra))
+		if opt := r.IsEdns0(); opt != nil {
+			fmt.Fprintf(stdout, "%s\n", optString(opt))
+		}
+	}
+	if !*noquestion && len(r.Question) > 0 {
+		if !*nocomments {
+			fmt.Fprintf(stdout, "\n;; %s SECTION:\n", names[0])
+		}
+		for _, q := range r.Question {
+			fmt.Fprintf(stdout, "%s\n", q.String())
+		}
+	}
+	if *answer {
+		printSection(names[1], r.Answer)
+	}
+	if *authority {
+		printSection(names[2], r.Ns)
+	}
+	if *additional {
+		printSection(names[3], r.Extra)
+	}
+}
+
+// printSection prints the RRs of a section under a header with name. The OPT
+// RR is skipped, it is shown in the OPT pseudo section.
+func printSection(name string, section []dns.RR) {
+	var rrs []dns.RR
+	for _, rr := range section {
+		if rr != nil && rr.Header().Rrtype != dns.TypeOPT {
+			rrs = append(rrs, rr)
+		}
+	}
+	if len(rrs) == 0 {
+		return
+	}
+	if !*nocomments {
+		fmt.Fprintf(stdout, "\n;; %s SECTION:\n", name)
+	}
+	for _, rr := range rrs {
+		if *comments {
+			fmt.Fprintf(stdout, "%s%s\n", rr, rrComment(rr))
+			continue
+		}
+		fmt.Fprintf(stdout, "%s\n", rr)
+	}
+}
+
+// keyTags holds the key tags of the DNSKEYs shortened with -short, as these
+// can't be computed once the public key is gone.
+var keyTags = map[*dns.DNSKEY]uint16{}
+
+// keyDS holds the DS records of the DNSKEYs shortened with -short.
+var keyDS = map[*dns.DNSKEY][]*dns.DS{}
+
+// dsRecords returns the SHA-256 and SHA-384 DS records for k.
+func dsRecords(k *dns.DNSKEY) []*dns.DS {
+	if ds, ok := keyDS[k]; ok {
+		return ds
+	}
+	var ds []*dns.DS
+	for _, h := range []uint8{dns.SHA256, dns.SHA384} {
+		if d := k.ToDS(h); d != nil {
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+// printDS prints the DS records for the key signing keys in the answer of a
+// DNSKEY query, with -show-ds this is done for all keys in any answer.
+func printDS(r *dns.Msg) {
+	if !*showDS && (len(r.Question) == 0 || r.Question[0].Qtype != dns.TypeDNSKEY) {
+		return
+	}
+	for _, rr := range r.Answer {
+		k, ok := rr.(*dns.DNSKEY)
+		if !ok || (!*showDS && k.Flags&dns.SEP == 0) {
+			continue
+		}
+		for _, ds := range dsRecords(k) {
+			fmt.Fprintf(stdout, ";; DS: %s\n", ds)
+		}
+	}
+}
+
+// rrComment returns a comment for DNSSEC records: the key tag, algorithm,
+// role of a key and the remaining validity of a signature. SVCB and HTTPS
+// records get their parameters spelled out.
+func rrComment(rr dns.RR) string {
+	switch t := rr.(type) {
+	case *dns.DNSKEY:
+		tag, ok := keyTags[t]
+		if !ok {
+			tag = t.KeyTag()
+		}
+		role := "ZSK"
+		if t.Flags&dns.SEP != 0 {
+			role = "KSK"
+		}
+		if t.Flags&dns.REVOKE != 0 {
+			role += ", REVOKED"
+		}
+		return fmt.Sprintf(" ; %s; alg = %s; key id = %d", role, algString(t.Algorithm), tag)
+	case *dns.DS:
+		return fmt.Sprintf(" ; alg = %s; key id = %d; digest = %s", algString(t.Algorithm), t.KeyTag, hashString(t.DigestType))
+	case *dns.RRSIG:
+		now := time.Now().UTC()
+		inception, expiration := sigTime(t.Inception), sigTime(t.Expiration)
+		validity := ""
+		switch {
+		case now.Before(inception):
+			validity = "not yet valid"
+		case now.After(expiration):
+			validity = fmt.Sprintf("expired %d days ago", int(now.Sub(expiration).Hours()/24))
+		default:
+			validity = fmt.Sprintf("expires in %d days", int(expiration.Sub(now).Hours()/24))
+		}
+		return fmt.Sprintf(" ; alg = %s; key id = %d; %s", algString(t.Algorithm), t.KeyTag, validity)
+	case *dns.SVCB:
+		return svcbComment(t)
+	case *dns.HTTPS:
+		return svcbComment(&t.SVCB)
+	}
+	return ""
+}
+
+// sigTime converts the serial arithmetic time of an RRSIG to a time.Time.
+func sigTime(t uint32) time.Time {
+	tm, _ := time.Parse("20060102150405", dns.TimeToString(t))
+	return tm
+}
+
+func algString(alg uint8) string {
+	if s, ok := dns.AlgorithmToString[alg]; ok {
+		return s
+	}
+	return fmt.Sprintf("%d", alg)
+}
+
+func hashString(h uint8) string {
+	if s, ok := dns.HashToString[h]; ok {
+		return s
+	}
+	return fmt.Sprintf("%d", h)
+}
@@ -17,6 +17,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"math/rand"
 	"net"
 	"os"
 	"strconv"
@@ -24,41 +25,110 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/miekg/exdns/dnstap"
 )
 
-// TODO(miek): serial in ixfr
-
 var (
-	dnskey       *dns.DNSKEY
 	short        = flag.Bool("short", false, "abbreviate long DNSSEC records")
 	dnssec       = flag.Bool("dnssec", false, "request DNSSEC records")
 	query        = flag.Bool("question", false, "show question")
 	check        = flag.Bool("check", false, "check internal DNSSEC consistency")
 	six          = flag.Bool("6", false, "use IPv6 only")
 	four         = flag.Bool("4", false, "use IPv4 only")
-	anchor       = flag.String("anchor", "", "use the DNSKEY in this file as trust anchor")
-	tsig         = flag.String("tsig", "", "request tsig with key: [hmac:]name:key")
+	anchor       = flag.String("anchor", "", "use the DNSKEY/DS records or IANA XML in this file as trust anchors")
+	dsf          = flag.String("ds", "", "only trust DNSKEYs that match this DS record, e.g. \"example.org. DS 12345 13 2 ...\"")
+	tsig         = flag.String("tsig", "", "request tsig with key: [hmac:]name:key, or gss-tsig[:server name] to negotiate a key with Kerberos")
+	tsigfile     = flag.String("tsigfile", "", "read the tsig key from this BIND key file or K*.private file")
 	port         = flag.Int("port", 53, "port number to use")
 	laddr        = flag.String("laddr", "", "local address to use")
 	aa           = flag.Bool("aa", false, "set AA flag in query")
 	ad           = flag.Bool("ad", false, "set AD flag in query")
 	cd           = flag.Bool("cd", false, "set CD flag in query")
 	rd           = flag.Bool("rd", true, "set RD flag in query")
-	fallback     = flag.Bool("fallback", false, "fallback to 4096 bytes bufsize and after that TCP")
+	fallback     = flag.Bool("fallback", false, "on truncation first retry with a 4096 bytes bufsize and after that TCP")
+	ignore       = flag.Bool("ignore", false, "do not retry with TCP when the reply is truncated")
 	tcp          = flag.Bool("tcp", false, "TCP mode, multiple queries are asked over the same connection")
 	timeoutDial  = flag.Duration("timeout-dial", 2*time.Second, "Dial timeout")
 	timeoutRead  = flag.Duration("timeout-read", 2*time.Second, "Read timeout")
 	timeoutWrite = flag.Duration("timeout-write", 2*time.Second, "Write timeout")
 	nsid         = flag.Bool("nsid", false, "set edns nsid option")
-	client       = flag.String("client", "", "set edns client-subnet option")
+	client       = flag.String("client", "", "set edns client-subnet option with this address or prefix, e.g. 192.0.2.0/24 or 0.0.0.0/0")
 	opcode       = flag.String("opcode", "query", "set opcode to query|update|notify")
 	rcode        = flag.String("rcode", "success", "set rcode to noerror|formerr|nxdomain|servfail|...")
+	jsonf        = flag.Bool("json", false, "print each response as a JSON object, not with -check, -question or zone transfers")
+	serial       = flag.Uint("serial", 0, "perform an IXFR with this serial")
+	keepalive    = flag.Bool("keepalive", false, "set edns tcp-keepalive option, only used with -tcp")
+	chain        = flag.String("chain", "", "set edns chain option with this closest trust point")
+	ede          = flag.Bool("ede", false, "use edns so the server can return extended dns errors")
+	noall        = flag.Bool("noall", false, "only display the sections explicitly asked for")
+	answer       = flag.Bool("answer", true, "display the answer section")
+	authority    = flag.Bool("authority", true, "display the authority section")
+	additional   = flag.Bool("additional", true, "display the additional section")
+	noquestion   = flag.Bool("noquestion", false, "do not display the question section")
+	nocomments   = flag.Bool("nocomments", false, "do not display comments, section headers and the footer")
+	count        = flag.Int("count", 1, "repeat each query this many times and show statistics")
+	interval     = flag.Duration("interval", time.Second, "wait this long between repeated queries")
+	hexf         = flag.Bool("hex", false, "show an annotated hexdump of the query and the reply")
+	rawIn        = flag.String("raw-in", "", "send the wire format query read from this file")
+	rawOut       = flag.String("raw-out", "", "write the wire format reply to this file")
+	comments     = flag.Bool("comments", false, "annotate DNSKEY, DS and RRSIG records with key tags, algorithms and validity, and SVCB/HTTPS records with their parameters")
+	aonly        = flag.Bool("aonly", false, "only query for A when no type is given, instead of A and AAAA")
+	dns64        = flag.Bool("dns64", false, "discover the DNS64 prefix of the server")
+	color        = flag.String("color", "auto", "colorize the output: auto, always or never")
+	parallel     = flag.Int("parallel", 1, "send up to this many queries concurrently")
+	compare      = flag.Bool("compare", false, "query all servers and show the differences in their answers")
+	minimizef    = flag.Bool("minimize", false, "send the queries a qname minimizing resolver would send before the full query")
+	anyExpandf   = flag.Bool("any-expand", false, "replace ANY queries with parallel queries for the types in -any-types")
+	anyTypes     = flag.String("any-types", "A,AAAA,CNAME,MX,TXT,NS,SOA,SRV,CAA,HTTPS,SVCB,DNSKEY,DS", "types queried for ANY with -any-expand")
+	tlsf         = flag.Bool("tls", false, "use DNS over TLS, the port defaults to 853")
+	tlsCA        = flag.String("tls-ca", "", "verify the server certificate with the CA certificates in this PEM file")
+	tlsCert      = flag.String("tls-cert", "", "client certificate for mutual TLS")
+	tlsKey       = flag.String("tls-key", "", "private key of the client certificate")
+	tlsName      = flag.String("tls-servername", "", "server name used for SNI and certificate verification")
+	spkiPin      = flag.String("spki-pin", "", "require a server certificate with this public key hash, sha256//base64, may be a comma separated list")
+	httpsf       = flag.Bool("https", false, "use DNS over HTTPS, the port defaults to 443")
+	dohPath      = flag.String("https-path", "/dns-query", "URL path used with -https")
+	httpVersion  = flag.String("http-version", "", "HTTP version used with -https: 1.1 or 2, default is 2 with a fallback to 1.1")
+	httpMethod   = flag.String("http-method", "POST", "HTTP method used with -https: GET or POST")
+	x20          = flag.Bool("0x20", false, "randomize the case of the qname and check the reply echoes it")
+	expire       = flag.Bool("expire", false, "set edns expire option, the primary returns the zone's expire timer for SOA queries")
+	nottl        = flag.Bool("nottl", false, "ignore TTLs when comparing answers with -compare")
+	showDS       = flag.Bool("show-ds", false, "show the DS records of all DNSKEYs in the answer, not only of the key signing keys")
+	svcbChase    = flag.Bool("svcb-chase", false, "follow SVCB/HTTPS aliases and look up the addresses of the targets")
+	trace        = flag.Bool("norec-trace", false, "send non-recursive queries starting at the server and follow the referrals")
+	walk         = flag.String("walk", "", "enumerate this zone by following its NSEC records")
+	walkFrom     = flag.String("walk-from", "", "resume -walk at this name")
+	walkRate     = flag.Int("walk-rate", 10, "send at most this many queries per second with -walk, 0 is unlimited")
+	llmnr        = flag.Bool("llmnr", false, "use LLMNR, without a server the query is sent to the multicast address on port 5355")
+	iface        = flag.String("interface", "", "network interface for the IPv6 LLMNR multicast address, which is link-local")
+	tosf         = flag.Int("tos", 0, "set the IP TOS (IPv6 traffic class) byte on the sockets")
+	dscp         = flag.Int("dscp", 0, "set the DSCP value on the sockets, this is -tos shifted 2 bits")
+	mark         = flag.Int("mark", 0, "set SO_MARK on the sockets, Linux only")
+	dnstapf      = flag.String("dnstap", "", "log queries and responses as dnstap to this file or tcp:host:port")
+	compliance   = flag.Bool("compliance", false, "run EDNS compliance tests against the servers for the zone")
+	pipeline     = flag.Bool("pipeline", false, "with -tcp send all queries at once and match the replies out of order")
+	multiq       = flag.Bool("multiq", false, "send all questions in a single message")
+	track        = flag.String("track-anchor", "", "track the DNSKEYs of the zone as RFC 5011 trust anchors, state is kept in this file")
+	reverse      addrList
+	pad          padFlag
 )
 
+func init() {
+	flag.Var(&reverse, "x", "reverse lookup of this address, may be repeated")
+	flag.Var(&pad, "pad", "add edns padding to queries, -pad=N pads to a multiple of N bytes")
+}
+
+// addrList holds the addresses given with -x.
+type addrList []string
+
+func (a *addrList) String() string     { return strings.Join(*a, ",") }
+func (a *addrList) Set(s string) error { *a = append(*a, s); return nil }
+
 func main() {
-	//serial := flag.Int("serial", 0, "perform an IXFR with this serial")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] [@server] [qtype...] [qclass...] [name ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [@server...] [qtype...] [qclass...] [name ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] -x address [@server...] [name ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Defaults for the options are read from ~/.config/q/config and Q_* environment variables.\n")
 		flag.PrintDefaults()
 	}
 
@@ -68,28 +138,76 @@ func main() {
 		qname  []string
 	)
 
+	if err := loadDefaults(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failure to read defaults: %s\n", err.Error())
+		os.Exit(2)
+	}
 	flag.Parse()
-	if *anchor != "" {
-		f, err := os.Open(*anchor)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failure to open %s: %s\n", *anchor, err.Error())
+	if *noall {
+		set := flagsSet()
+		*answer = *answer && set["answer"]
+		*authority = *authority && set["authority"]
+		*additional = *additional && set["additional"]
+		*noquestion = *noquestion || !set["noquestion"]
+		*nocomments = *nocomments || !set["nocomments"]
+	}
+	if *jsonf && (*check || *query) {
+		fmt.Fprintf(os.Stderr, "-json can not be used with -check or -question, their output is not JSON\n")
+		os.Exit(2)
+	}
+	if *dnstapf != "" {
+		var err error
+		if tap, err = dnstap.Open(*dnstapf, "q", *timeoutDial); err != nil {
+			fmt.Fprintf(os.Stderr, "Failure to open dnstap output %s: %s\n", *dnstapf, err.Error())
+			os.Exit(2)
 		}
-		r, err := dns.ReadRR(f, *anchor)
+		defer tap.Close()
+	}
+	if *tsigfile != "" {
+		s, err := tsigKeyFile(*tsigfile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failure to read an RR from %s: %s\n", *anchor, err.Error())
+			fmt.Fprintf(os.Stderr, "Failure to read TSIG key from %s: %s\n", *tsigfile, err.Error())
+			os.Exit(2)
+		}
+		*tsig = s
+	}
+	if *tlsf || *httpsf || *llmnr {
+		set := flagsSet()["port"]
+		if !set && *llmnr {
+			*port = 5355
+		}
+		if !set && *tlsf {
+			*port = 853
+		}
+		if !set && *httpsf {
+			*port = 443
 		}
-		if k, ok := r.(*dns.DNSKEY); !ok {
-			fmt.Fprintf(os.Stderr, "No DNSKEY read from %s\n", *anchor)
+	}
+	if useColor(*color) {
+		cw := &colorWriter{w: os.Stdout}
+		stdout = cw
+		defer cw.Flush()
+	}
+	if *anchor != "" {
+		if err := readAnchor(*anchor); err != nil {
+			fmt.Fprintf(os.Stderr, "Failure to read trust anchor from %s: %s\n", *anchor, err.Error())
+		}
+	}
+	if *dsf != "" {
+		rr, err := dns.NewRR(*dsf)
+		if ds, ok := rr.(*dns.DS); ok && err == nil {
+			anchorDS = append(anchorDS, ds)
 		} else {
-			dnskey = k
+			fmt.Fprintf(os.Stderr, "Failure to parse DS record: %s\n", *dsf)
+			os.Exit(2)
 		}
 	}
 
-	var nameserver string
+	var nameservers []string
 	for _, arg := range flag.Args() {
 		// If it starts with @ it is a nameserver
 		if arg[0] == '@' {
-			nameserver = arg
+			nameservers = append(nameservers, arg)
 			continue
 		}
 		// First class, then type, to make ANY queries possible
@@ -122,12 +240,20 @@ func main() {
 		// Anything else is a qname
 		qname = append(qname, arg)
 	}
-	if len(qname) == 0 {
+	if len(qname) == 0 && len(reverse) == 0 {
 		qname = []string{"."}
 		if len(qtype) == 0 {
 			qtype = append(qtype, dns.TypeNS)
 		}
 	}
+	if len(qtype) == 0 && *anyExpandf {
+		qtype = append(qtype, dns.TypeANY)
+	}
+	// Without a type ask for both A and AAAA, like host does.
+	dual := len(qtype) == 0 && !*aonly
+	if dual && *parallel < 2 {
+		*parallel = 2
+	}
 	if len(qtype) == 0 {
 		qtype = append(qtype, dns.TypeA)
 	}
@@ -135,26 +261,63 @@ func main() {
 		qclass = append(qclass, dns.ClassINET)
 	}
 
-	if len(nameserver) == 0 {
-		conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	// Reverse lookups come first, they are always PTR queries in class IN.
+	var question []dns.Question
+	for _, a := range reverse {
+		name, err := dns.ReverseAddr(a)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintf(os.Stderr, "Failure to parse IP address: %s\n", a)
 			os.Exit(2)
 		}
-		nameserver = "@" + conf.Servers[0]
+		question = append(question, dns.Question{Name: name, Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	}
+	qt := dns.TypeA
+	qc := uint16(dns.ClassINET)
+	for i, v := range qname {
+		if i < len(qtype) {
+			qt = qtype[i]
+		}
+		if i < len(qclass) {
+			qc = qclass[i]
+		}
+		if dual {
+			question = append(question, dns.Question{Name: dns.Fqdn(v), Qtype: dns.TypeA, Qclass: qc})
+			question = append(question, dns.Question{Name: dns.Fqdn(v), Qtype: dns.TypeAAAA, Qclass: qc})
+			continue
+		}
+		question = append(question, dns.Question{Name: dns.Fqdn(v), Qtype: qt, Qclass: qc})
 	}
 
-	nameserver = string([]byte(nameserver)[1:]) // chop off @
-	// if the nameserver is from /etc/resolv.conf the [ and ] are already
-	// added, thereby breaking net.ParseIP. Check for this and don't
-	// fully qualify such a name
-	if nameserver[0] == '[' && nameserver[len(nameserver)-1] == ']' {
-		nameserver = nameserver[1 : len(nameserver)-1]
+	if *jsonf {
+		for _, q := range question {
+			if q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR {
+				fmt.Fprintf(os.Stderr, "-json can not be used with zone transfers\n")
+				os.Exit(2)
+			}
+		}
 	}
-	if i := net.ParseIP(nameserver); i != nil {
-		nameserver = net.JoinHostPort(nameserver, strconv.Itoa(*port))
-	} else {
-		nameserver = dns.Fqdn(nameserver) + ":" + strconv.Itoa(*port)
+
+	if *llmnr {
+		for i := range nameservers {
+			nameservers[i] = serverAddr(nameservers[i])
+		}
+		llmnrQuery(question, nameservers, *timeoutRead)
+		return
+	}
+
+	if len(nameservers) == 0 && defaultServer != "" {
+		nameservers = []string{"@" + strings.TrimPrefix(defaultServer, "@")}
+	}
+	if len(nameservers) == 0 {
+		conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		nameservers = []string{"@" + conf.Servers[0]}
+	}
+	for i := range nameservers {
+		nameservers[i] = serverAddr(nameservers[i])
 	}
 	c := new(dns.Client)
 	t := new(dns.Transfer)
@@ -174,12 +337,32 @@ func main() {
 			c.Net = "tcp6"
 		}
 	}
+	if *tlsf || *httpsf {
+		c.Net = "tcp-tls"
+		if *four {
+			c.Net = "tcp4-tls"
+		}
+		if *six {
+			c.Net = "tcp6-tls"
+		}
+		config, err := tlsConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failure to setup TLS: %s\n", err.Error())
+			os.Exit(2)
+		}
+		c.TLSConfig = config
+	}
 	c.DialTimeout = *timeoutDial
 	c.ReadTimeout = *timeoutRead
 	c.WriteTimeout = *timeoutWrite
 
+	if tos() > 0 || *mark > 0 {
+		c.Dialer = &net.Dialer{Timeout: c.DialTimeout, Control: control}
+	}
 	if *laddr != "" {
-		c.Dialer = &net.Dialer{Timeout: c.DialTimeout}
+		if c.Dialer == nil {
+			c.Dialer = &net.Dialer{Timeout: c.DialTimeout}
+		}
 		ip := net.ParseIP(*laddr)
 		if *tcp {
 			c.Dialer.LocalAddr = &net.TCPAddr{IP: ip}
@@ -188,6 +371,56 @@ func main() {
 		}
 	}
 
+	// With GSS-TSIG the key is negotiated with the first server, the name of
+	// the server is needed for Kerberos.
+	var gssKey string
+	if strings.HasPrefix(*tsig, "gss-tsig") {
+		host, err := gssHost(*tsig, nameservers[0])
+		if err == nil {
+			var p dns.TsigProvider
+			if gssKey, p, err = gssNegotiate(c, nameservers[0], host); err == nil {
+				c.TsigProvider, t.TsigProvider = p, p
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failure to negotiate a GSS-TSIG key: %s\n", err.Error())
+			os.Exit(2)
+		}
+	}
+
+	if !*tlsf && !*httpsf {
+		// With TLS the name is needed to verify the certificate, Go's dialer
+		// does RFC 6555 for TCP anyway.
+		for i := range nameservers {
+			nameservers[i] = happyEyeballs(c, nameservers[i])
+		}
+	}
+
+	if *dns64 {
+		dns64Discover(c, nameservers)
+		return
+	}
+	if *compliance {
+		zone := "."
+		if len(qname) > 0 {
+			zone = dns.Fqdn(qname[0])
+		}
+		complianceCheck(c, zone, nameservers)
+		return
+	}
+	if *walk != "" {
+		walkZone(c, *walk, *walkFrom, *walkRate, nameservers)
+		return
+	}
+	if *track != "" {
+		zone := "."
+		if len(qname) > 0 {
+			zone = dns.Fqdn(qname[0])
+		}
+		trackAnchor(c, zone, *track, nameservers)
+		return
+	}
+
 	m := &dns.Msg{
 		MsgHdr: dns.MsgHdr{
 			Authoritative:     *aa,
@@ -206,7 +439,11 @@ func main() {
 		m.Rcode = rc
 	}
 
-	if *dnssec || *nsid || *client != "" {
+	if *keepalive && !*tcp {
+		fmt.Fprintf(os.Stderr, ";; -keepalive is only used with -tcp\n")
+		*keepalive = false
+	}
+	if *dnssec || *nsid || *client != "" || pad.block > 0 || *ede || *keepalive || *chain != "" || *expire {
 		o := &dns.OPT{
 			Hdr: dns.RR_Header{
 				Name:   ".",
@@ -226,121 +463,63 @@ func main() {
 			o.SetUDPSize(dns.DefaultMsgSize)
 		}
 		if *client != "" {
-			e := &dns.EDNS0_SUBNET{
-				Code:          dns.EDNS0SUBNET,
-				Address:       net.ParseIP(*client),
-				Family:        1, // IP4
-				SourceNetmask: net.IPv4len * 8,
-			}
-
-			if e.Address == nil {
-				fmt.Fprintf(os.Stderr, "Failure to parse IP address: %s\n", *client)
+			e, err := subnetOption(*client)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failure to parse client subnet %s: %s\n", *client, err.Error())
 				return
 			}
-
-			if e.Address.To4() == nil {
-				e.Family = 2 // IP6
-				e.SourceNetmask = net.IPv6len * 8
-			}
 			o.Option = append(o.Option, e)
 		}
-		m.Extra = append(m.Extra, o)
-	}
-	if *tcp {
-		co := new(dns.Conn)
-		tcp := "tcp"
-		if *six {
-			tcp = "tcp6"
+		if *chain != "" {
+			e, err := chainOption(*chain)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failure to parse closest trust point %s: %s\n", *chain, err.Error())
+				return
+			}
+			o.Option = append(o.Option, e)
 		}
-		var err error
-
-		if c.Dialer != nil {
-			co.Conn, err = c.Dialer.Dial(tcp, nameserver)
-		} else {
-			co.Conn, err = net.DialTimeout(tcp, nameserver, *timeoutDial)
+		if *expire {
+			// Clients send the option empty (RFC 7314).
+			o.Option = append(o.Option, &dns.EDNS0_EXPIRE{Code: dns.EDNS0EXPIRE, Empty: true})
 		}
-
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Dialing "+nameserver+" failed: "+err.Error()+"\n")
-			return
+		if *keepalive {
+			// Clients send the option without a timeout (RFC 7828).
+			o.Option = append(o.Option, &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE})
 		}
-
-		defer co.Close()
-		qt := dns.TypeA
-		qc := uint16(dns.ClassINET)
-		for i, v := range qname {
-			if i < len(qtype) {
-				qt = qtype[i]
-			}
-			if i < len(qclass) {
-				qc = qclass[i]
-			}
-			m.Question[0] = dns.Question{Name: dns.Fqdn(v), Qtype: qt, Qclass: qc}
-			m.Id = dns.Id()
-			if *tsig != "" {
-				if algo, name, secret, ok := tsigKeyParse(*tsig); ok {
-					m.SetTsig(name, algo, 300, time.Now().Unix())
-					c.TsigSecret = map[string]string{name: secret}
-					t.TsigSecret = map[string]string{name: secret}
-				} else {
-					fmt.Fprintf(os.Stderr, ";; TSIG key data error\n")
-					continue
-				}
-			}
-			co.SetReadDeadline(time.Now().Add(*timeoutRead))
-			co.SetWriteDeadline(time.Now().Add(*timeoutWrite))
-
-			if *query {
-				fmt.Printf("%s", m.String())
-				fmt.Printf("\n;; size: %d bytes\n\n", m.Len())
-			}
-			then := time.Now()
-			if err := co.WriteMsg(m); err != nil {
-				fmt.Fprintf(os.Stderr, ";; %s\n", err.Error())
-				continue
-			}
-			r, err := co.ReadMsg()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, ";; %s\n", err.Error())
-				continue
-			}
-			rtt := time.Since(then)
-			if r.Id != m.Id {
-				fmt.Fprintf(os.Stderr, "Id mismatch\n")
-				continue
-			}
-
-			if *check {
-				sigCheck(r, nameserver, true)
-				denialCheck(r)
-				fmt.Println()
-			}
-			if *short {
-				shortenMsg(r)
-			}
-
-			fmt.Printf("%v", r)
-			fmt.Printf("\n;; query time: %.3d µs, server: %s(%s), size: %d bytes\n", rtt/1e3, nameserver, tcp, r.Len())
+		if o.UDPSize() == 0 {
+			o.SetUDPSize(dns.DefaultMsgSize)
 		}
-		return
+		m.Extra = append(m.Extra, o)
 	}
+	defer func() {
+		for _, co := range conns {
+			co.Close()
+		}
+	}()
 
-	qt := dns.TypeA
-	qc := uint16(dns.ClassINET)
-
-Query:
-	for i, v := range qname {
-		if i < len(qtype) {
-			qt = qtype[i]
+	// Create a message for each question, using m as the template.
+	msgs := make([]*dns.Msg, 0, len(question))
+	for _, q := range question {
+		mq := m.Copy()
+		mq.Question[0] = q
+		mq.Id = dns.Id()
+		if *x20 {
+			mq.Question[0].Name = randomCase(q.Name)
 		}
-		if i < len(qclass) {
-			qc = qclass[i]
+		if q.Qtype == dns.TypeIXFR {
+			mq.Ns = []dns.RR{&dns.SOA{
+				Hdr:    dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSOA, Class: q.Qclass},
+				Ns:     ".",
+				Mbox:   ".",
+				Serial: uint32(*serial),
+			}}
 		}
-		m.Question[0] = dns.Question{Name: dns.Fqdn(v), Qtype: qt, Qclass: qc}
-		m.Id = dns.Id()
-		if *tsig != "" {
+		padMsg(mq, pad.block)
+		if gssKey != "" {
+			mq.SetTsig(gssKey, gssTsig, 300, time.Now().Unix())
+		} else if *tsig != "" {
 			if algo, name, secret, ok := tsigKeyParse(*tsig); ok {
-				m.SetTsig(name, algo, 300, time.Now().Unix())
+				mq.SetTsig(name, algo, 300, time.Now().Unix())
 				c.TsigSecret = map[string]string{name: secret}
 				t.TsigSecret = map[string]string{name: secret}
 			} else {
@@ -348,80 +527,217 @@ Query:
 				continue
 			}
 		}
+		msgs = append(msgs, mq)
+	}
+	if *multiq && len(msgs) > 1 {
+		// Put all questions in the first message, the count is taken from
+		// the slice when packing.
+		mq := msgs[0]
+		for _, m1 := range msgs[1:] {
+			mq.Question = append(mq.Question, m1.Question[0])
+		}
+		padMsg(mq, pad.block)
+		msgs = msgs[:1]
+	}
+	if *rawIn != "" {
+		mq, err := readRaw(*rawIn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failure to read a message from %s: %s\n", *rawIn, err.Error())
+			os.Exit(2)
+		}
+		msgs = []*dns.Msg{mq}
+	}
+
+	var prefetch []chan result
+	switch {
+	case *pipeline && !strings.HasPrefix(c.Net, "tcp"):
+		fmt.Fprintf(os.Stderr, ";; -pipeline is only used with -tcp\n")
+	case *pipeline && !*compare && !*minimizef && *count <= 1:
+		prefetch = queryPipelined(c, msgs, nameservers[0])
+	case *parallel > 1 && !*compare && !*minimizef && *count <= 1:
+		prefetch = queryParallel(c, msgs, nameservers, *parallel)
+	}
+
+Query:
+	for i, m := range msgs {
+		q := m.Question[0]
 		if *query {
-			fmt.Printf("%s", m.String())
-			fmt.Printf("\n;; size: %d bytes\n\n", m.Len())
+			fmt.Fprintf(stdout, "%s", m.String())
+			fmt.Fprintf(stdout, "\n;; size: %d bytes\n\n", m.Len())
 		}
-		if qt == dns.TypeAXFR || qt == dns.TypeIXFR {
-			env, err := t.In(m, nameserver)
+		if q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR {
+			var (
+				env chan *dns.Envelope
+				err error
+			)
+			for _, nameserver := range nameservers {
+				if env, err = t.In(m, nameserver); err == nil {
+					break
+				}
+				fmt.Fprintf(stdout, ";; %s: %s\n", nameserver, err.Error())
+			}
 			if err != nil {
-				fmt.Printf(";; %s\n", err.Error())
 				continue
 			}
-			var envelope, record int
+			var (
+				envelope int
+				rrs      []dns.RR
+			)
 			for e := range env {
 				if e.Error != nil {
-					fmt.Printf(";; %s\n", e.Error.Error())
+					fmt.Fprintf(stdout, ";; %s\n", e.Error.Error())
 					continue Query
 				}
-				for _, r := range e.RR {
-					fmt.Printf("%s\n", r)
-				}
-				record += len(e.RR)
+				rrs = append(rrs, e.RR...)
 				envelope++
 			}
-			fmt.Printf("\n;; xfr size: %d records (envelopes %d)\n", record, envelope)
+			if q.Qtype == dns.TypeIXFR {
+				printIxfr(rrs)
+			} else {
+				for _, r := range rrs {
+					fmt.Fprintf(stdout, "%s\n", r)
+				}
+			}
+			fmt.Fprintf(stdout, "\n;; xfr size: %d records (envelopes %d)\n", len(rrs), envelope)
 			continue
 		}
-		r, rtt, err := c.Exchange(m, nameserver)
+		if *minimizef {
+			minimize(c, m, nameservers)
+		}
+		if *trace {
+			traceReferrals(c, m, nameservers[0])
+			continue
+		}
+		if *anyExpandf && q.Qtype == dns.TypeANY {
+			anyExpand(c, m, nameservers)
+			continue
+		}
+		if *compare {
+			compareServers(c, m, nameservers)
+			continue
+		}
+		if *count > 1 {
+			repeatQuery(c, m, nameservers[0])
+			continue
+		}
+		var res result
+		if prefetch != nil {
+			res = <-prefetch[i]
+			printFailed(res)
+		} else {
+			res = queryServers(c, m, nameservers)
+		}
+		r, err, nameserver := res.r, res.err, res.server
+		bufsize := false
 	Redo:
 		switch err {
 		case nil:
 			//do nothing
 		default:
-			fmt.Printf(";; %s\n", err.Error())
+			fmt.Fprintf(stdout, ";; %s\n", err.Error())
 			continue
 		}
-		if(r.Truncated) {
-			if *fallback {
-				if !*dnssec {
-					fmt.Printf(";; Truncated, trying %d bytes bufsize\n", dns.DefaultMsgSize)
-					o := new(dns.OPT)
-					o.Hdr.Name = "."
-					o.Hdr.Rrtype = dns.TypeOPT
-					o.SetUDPSize(dns.DefaultMsgSize)
-					m.Extra = append(m.Extra, o)
-					r, rtt, err = c.Exchange(m, nameserver)
-					*dnssec = true
-					goto Redo
-				} else {
-					// First EDNS, then TCP
-					fmt.Printf(";; Truncated, trying TCP\n")
-					c.Net = "tcp"
-					r, rtt, err = c.Exchange(m, nameserver)
-					*fallback = false
-					goto Redo
+		if r.Truncated && !*ignore && !strings.HasPrefix(res.net, "tcp") {
+			// With -fallback first retry with a 4096 byte bufsize, then TCP.
+			if o := m.IsEdns0(); *fallback && !bufsize && (o == nil || o.UDPSize() < dns.DefaultMsgSize) {
+				fmt.Fprintf(stdout, ";; Truncated, trying %d bytes bufsize\n", dns.DefaultMsgSize)
+				if o == nil {
+					o = &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+					m.Extra = append([]dns.RR{o}, m.Extra...)
 				}
+				o.SetUDPSize(dns.DefaultMsgSize)
+				bufsize = true
+				res = exchange(c, m, nameserver)
+				r, err = res.r, res.err
+				goto Redo
 			}
-			fmt.Printf(";; Truncated\n")
+			fmt.Fprintf(stdout, ";; Truncated, retrying in TCP mode\n")
+			tc := *c
+			tc.Net = strings.Replace(c.Net, "udp", "tcp", 1)
+			res = exchange(&tc, m, nameserver)
+			r, err = res.r, res.err
+			goto Redo
+		}
+		if r.Truncated {
+			fmt.Fprintf(stdout, ";; Truncated\n")
 		}
 		if r.Id != m.Id {
 			fmt.Fprintf(os.Stderr, "Id mismatch\n")
 			return
 		}
+		if *multiq {
+			fmt.Fprintf(stdout, ";; multiq: sent %d questions, reply has %d questions and %s\n", len(m.Question), len(r.Question), dns.RcodeToString[r.Rcode])
+		}
+		if *x20 && (len(r.Question) == 0 || r.Question[0].Name != m.Question[0].Name) {
+			name := "no question"
+			if len(r.Question) > 0 {
+				name = r.Question[0].Name
+			}
+			fmt.Fprintf(stdout, ";? 0x20: sent %s, but %s was returned\n", m.Question[0].Name, name)
+		}
+		if *hexf {
+			hexdump("QUERY", res.query)
+			hexdump("REPLY", res.reply)
+		}
+		if *rawOut != "" {
+			name := *rawOut
+			if len(msgs) > 1 {
+				name += "." + strconv.Itoa(i+1)
+			}
+			if err := os.WriteFile(name, res.reply, 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Failure to write %s: %s\n", name, err.Error())
+			}
+		}
 
 		if *check {
 			sigCheck(r, nameserver, *tcp)
 			denialCheck(r)
-			fmt.Println()
+			fmt.Fprintln(stdout)
 		}
 		if *short {
 			shortenMsg(r)
 		}
 
-		fmt.Printf("%v", r)
-		fmt.Printf("\n;; query time: %.3d µs, server: %s(%s), size: %d bytes\n", rtt/1e3, nameserver, c.Net, r.Len())
+		printMsg(res)
+		if *svcbChase {
+			chaseSVCB(c, m, r, nameservers)
+		}
+	}
+}
+
+// tos returns the TOS byte from -dscp or -tos.
+func tos() int {
+	if *dscp > 0 {
+		return *dscp << 2
+	}
+	return *tosf
+}
+
+// randomCase randomizes the case of the letters in name, see
+// draft-vixie-dnsext-dns0x20.
+func randomCase(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if ('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') && rand.Intn(2) == 0 {
+			b[i] ^= 0x20
+		}
 	}
+	return string(b)
+}
+
+// serverAddr returns the address to use for the @server argument s.
+func serverAddr(s string) string {
+	nameserver := s[1:] // chop off @
+	// if the nameserver is from /etc/resolv.conf the [ and ] are already
+	// added, thereby breaking net.ParseIP. Check for this and don't
+	// fully qualify such a name
+	if nameserver[0] == '[' && nameserver[len(nameserver)-1] == ']' {
+		nameserver = nameserver[1 : len(nameserver)-1]
+	}
+	if i := net.ParseIP(nameserver); i != nil {
+		return net.JoinHostPort(nameserver, strconv.Itoa(*port))
+	}
+	return dns.Fqdn(nameserver) + ":" + strconv.Itoa(*port)
 }
 
 func tsigKeyParse(s string) (algo, name, secret string, ok bool) {
@@ -435,15 +751,20 @@ func tsigKeyParse(s string) (algo, name, secret string, ok bool) {
 			return "hmac-md5.sig-alg.reg.int.", dns.Fqdn(s1[1]), s1[2], true
 		case "hmac-sha1":
 			return "hmac-sha1.", dns.Fqdn(s1[1]), s1[2], true
+		case "hmac-sha224":
+			return "hmac-sha224.", dns.Fqdn(s1[1]), s1[2], true
 		case "hmac-sha256":
 			return "hmac-sha256.", dns.Fqdn(s1[1]), s1[2], true
+		case "hmac-sha384":
+			return "hmac-sha384.", dns.Fqdn(s1[1]), s1[2], true
+		case "hmac-sha512":
+			return "hmac-sha512.", dns.Fqdn(s1[1]), s1[2], true
 		}
 	}
 	return
 }
 
 func sectionCheck(set []dns.RR, server string, tcp bool) {
-	var key *dns.DNSKEY
 	for _, rr := range set {
 		if rr.Header().Rrtype == dns.TypeRRSIG {
 			var expired string
@@ -451,24 +772,16 @@ func sectionCheck(set []dns.RR, server string, tcp bool) {
 				expired = "(*EXPIRED*)"
 			}
 			rrset := getRRset(set, rr.Header().Name, rr.(*dns.RRSIG).TypeCovered)
-			if dnskey == nil {
-				key = getKey(rr.(*dns.RRSIG).SignerName, rr.(*dns.RRSIG).KeyTag, server, tcp)
-			} else {
-				key = dnskey
-			}
+			key, where := findKey(rr.(*dns.RRSIG).SignerName, rr.(*dns.RRSIG).KeyTag, server, tcp)
 			if key == nil {
-				fmt.Printf(";? DNSKEY %s/%d not found\n", rr.(*dns.RRSIG).SignerName, rr.(*dns.RRSIG).KeyTag)
+				fmt.Fprintf(stdout, ";? DNSKEY %s/%d not found\n", rr.(*dns.RRSIG).SignerName, rr.(*dns.RRSIG).KeyTag)
 				continue
 			}
-			where := "net"
-			if dnskey != nil {
-				where = "disk"
-			}
 			if err := rr.(*dns.RRSIG).Verify(key, rrset); err != nil {
-				fmt.Printf(";- Bogus signature, %s does not validate (DNSKEY %s/%d/%s) [%s] %s\n",
+				fmt.Fprintf(stdout, ";- Bogus signature, %s does not validate (DNSKEY %s/%d/%s) [%s] %s\n",
 					shortSig(rr.(*dns.RRSIG)), key.Header().Name, key.KeyTag(), where, err.Error(), expired)
 			} else {
-				fmt.Printf(";+ Secure signature, %s validates (DNSKEY %s/%d/%s) %s\n", shortSig(rr.(*dns.RRSIG)), key.Header().Name, key.KeyTag(), where, expired)
+				fmt.Fprintf(stdout, ";+ Secure signature, %s validates (DNSKEY %s/%d/%s) %s\n", shortSig(rr.(*dns.RRSIG)), key.Header().Name, key.KeyTag(), where, expired)
 			}
 		}
 	}
@@ -484,23 +797,238 @@ func sigCheck(in *dns.Msg, server string, tcp bool) {
 
 // Check if there is need for authenticated denial of existence check
 func denialCheck(in *dns.Msg) {
-	var denial []dns.RR
+	var nsec, nsec3 []dns.RR
 	// nsec(3) lives in the auth section
 	for _, rr := range in.Ns {
-		if rr.Header().Rrtype == dns.TypeNSEC {
-			return
+		switch rr.Header().Rrtype {
+		case dns.TypeNSEC:
+			nsec = append(nsec, rr)
+		case dns.TypeNSEC3:
+			nsec3 = append(nsec3, rr)
 		}
+	}
+
+	if len(nsec) > 0 {
+		denial(nsec, in)
+	}
+	if len(nsec3) > 0 {
+		denial3(nsec3, in)
+	}
+	nsec3Audit(in)
+}
+
+// nsec3Audit warns about NSEC3 parameters that RFC 9276 advises against: more
+// than 0 iterations, a salt and, for information, the use of opt-out.
+func nsec3Audit(in *dns.Msg) {
+	seen := map[string]bool{}
+	for _, rr := range append(append(in.Answer, in.Ns...), in.Extra...) {
+		var (
+			iterations uint16
+			salt       string
+			optout     bool
+		)
+		switch t := rr.(type) {
+		case *dns.NSEC3:
+			iterations, salt, optout = t.Iterations, t.Salt, t.Flags&1 == 1
+		case *dns.NSEC3PARAM:
+			iterations, salt = t.Iterations, t.Salt
+		default:
+			continue
+		}
+		zone := rr.Header().Name
 		if rr.Header().Rrtype == dns.TypeNSEC3 {
-			denial = append(denial, rr)
+			if i := strings.Index(zone, "."); i >= 0 {
+				zone = zone[i+1:]
+			}
+		}
+		if salt == "-" {
+			salt = ""
+		}
+		key := fmt.Sprintf("%s %d %s %t", dns.CanonicalName(zone), iterations, salt, optout)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if iterations > 0 {
+			fmt.Fprintf(stdout, ";? NSEC3 for %s uses %d additional iterations, RFC 9276 says 0\n", zone, iterations)
+		}
+		if salt != "" {
+			fmt.Fprintf(stdout, ";? NSEC3 for %s uses salt %s, RFC 9276 says to use no salt\n", zone, salt)
+		}
+		if optout {
+			fmt.Fprintf(stdout, ";? NSEC3 for %s uses opt-out, unsigned delegations are not covered\n", zone)
+		}
+	}
+}
+
+// NSEC Helper
+func denial(nsec []dns.RR, in *dns.Msg) {
+	qname := in.Question[0].Name
+	qtype := in.Question[0].Qtype
+	switch in.Rcode {
+	case dns.RcodeSuccess:
+		if len(in.Answer) > 0 {
+			// Only a wildcard expanded answer needs a denial proof: the
+			// qname itself must not exist.
+			for _, rr := range in.Answer {
+				sig, ok := rr.(*dns.RRSIG)
+				if !ok || int(sig.Labels) >= dns.CountLabel(sig.Hdr.Name) {
+					continue
+				}
+				nc := nextCloser(sig.Hdr.Name, int(sig.Labels))
+				n := nsecCover(nsec, nc)
+				if n == nil {
+					fmt.Fprintf(stdout, ";- Denial, next closer %s not covered\n", nc)
+					fmt.Fprintf(stdout, ";- Denial, failed authenticated denial of existence proof for wildcard expansion\n")
+					return
+				}
+				fmt.Fprintf(stdout, ";+ Denial, next closer %s, covered by %s -> %s\n", nc, n.Hdr.Name, n.NextDomain)
+				fmt.Fprintf(stdout, ";+ Denial, secure authenticated denial of existence proof for wildcard expansion\n")
+				return
+			}
+			return
+		}
+		// qname should match nsec, type should not be in bitmap
+		for _, rr := range nsec {
+			n := rr.(*dns.NSEC)
+			if !strings.EqualFold(n.Hdr.Name, qname) {
+				continue
+			}
+			if !nsecDenies(n, qtype) {
+				fmt.Fprintf(stdout, ";- Denial, found type, %s, in bitmap\n", dns.TypeToString[qtype])
+				fmt.Fprintf(stdout, ";- Denial, failed authenticated denial of existence proof for no data\n")
+				return
+			}
+			fmt.Fprintf(stdout, ";+ Denial, matching record, %s, found and type %s denied\n", qname, dns.TypeToString[qtype])
+			fmt.Fprintf(stdout, ";+ Denial, secure authenticated denial of existence proof for no data\n")
+			return
+		}
+		// No exact match, this can still be a wildcard no data response.
+		n := nsecCover(nsec, qname)
+		if n == nil {
+			fmt.Fprintf(stdout, ";- Denial, no record matching or covering %s\n", qname)
+			fmt.Fprintf(stdout, ";- Denial, failed authenticated denial of existence proof for no data\n")
+			return
+		}
+		ce := closestEncloser(qname, n)
+		wc := "*." + ce
+		if ce == "." {
+			wc = "*."
+		}
+		for _, rr := range nsec {
+			w := rr.(*dns.NSEC)
+			if !strings.EqualFold(w.Hdr.Name, wc) {
+				continue
+			}
+			if !nsecDenies(w, qtype) {
+				fmt.Fprintf(stdout, ";- Denial, found type, %s, in wildcard bitmap\n", dns.TypeToString[qtype])
+				break
+			}
+			fmt.Fprintf(stdout, ";+ Denial, next closer %s, covered by %s -> %s\n", qname, n.Hdr.Name, n.NextDomain)
+			fmt.Fprintf(stdout, ";+ Denial, source of synthesis, %s, found and type %s denied\n", wc, dns.TypeToString[qtype])
+			fmt.Fprintf(stdout, ";+ Denial, secure authenticated denial of existence proof for wildcard no data\n")
+			return
+		}
+		fmt.Fprintf(stdout, ";- Denial, failed authenticated denial of existence proof for no data\n")
+		return
+	case dns.RcodeNameError: // NXDOMAIN Proof
+		n := nsecCover(nsec, qname)
+		if n == nil {
+			fmt.Fprintf(stdout, ";- Denial, qname %s not covered\n", qname)
+			fmt.Fprintf(stdout, ";- Denial, failed authenticated denial of existence proof for name error\n")
+			return
+		}
+		fmt.Fprintf(stdout, ";+ Denial, qname %s, covered by %s -> %s\n", qname, n.Hdr.Name, n.NextDomain)
+		ce := closestEncloser(qname, n)
+		fmt.Fprintf(stdout, ";+ Denial, closest encloser, %s\n", ce)
+		wc := "*." + ce
+		if ce == "." {
+			wc = "*."
+		}
+		w := nsecCover(nsec, wc)
+		if w == nil {
+			fmt.Fprintf(stdout, ";- Denial, source of synthesis %s not covered\n", wc)
+			fmt.Fprintf(stdout, ";- Denial, failed authenticated denial of existence proof for name error\n")
+			return
+		}
+		fmt.Fprintf(stdout, ";+ Denial, source of synthesis %s, covered by %s -> %s\n", wc, w.Hdr.Name, w.NextDomain)
+		fmt.Fprintf(stdout, ";+ Denial, secure authenticated denial of existence proof for name error\n")
+		return
+	}
+}
+
+// nsecDenies returns true when the bitmap of n shows that qtype (and CNAME)
+// do not exist at its owner name.
+func nsecDenies(n *dns.NSEC, qtype uint16) bool {
+	for _, t := range n.TypeBitMap {
+		if t == qtype || t == dns.TypeCNAME {
+			return false
+		}
+	}
+	return true
+}
+
+// nsecCover returns the NSEC record that covers name, or nil if there is none.
+func nsecCover(nsec []dns.RR, name string) *dns.NSEC {
+	for _, rr := range nsec {
+		n := rr.(*dns.NSEC)
+		if canonicalCompare(n.Hdr.Name, name) >= 0 {
 			continue
 		}
+		// The last NSEC in the zone points back to the apex.
+		if canonicalCompare(name, n.NextDomain) < 0 || canonicalCompare(n.NextDomain, n.Hdr.Name) <= 0 {
+			return n
+		}
 	}
+	return nil
+}
 
-	if len(denial) > 0 {
-		denial3(denial, in)
+// closestEncloser returns the closest encloser of name as proven by the
+// covering NSEC n: the longest ancestor shared with either its owner or its
+// next domain name.
+func closestEncloser(name string, n *dns.NSEC) string {
+	l := dns.CompareDomainName(name, n.Hdr.Name)
+	if l1 := dns.CompareDomainName(name, n.NextDomain); l1 > l {
+		l = l1
 	}
-	fmt.Printf(";+ Unimplemented: check for denial-of-existence for nsec\n")
-	return
+	return ancestor(name, l)
+}
+
+// nextCloser returns the next closer name for a wildcard expansion of name
+// with a signature that has labels labels.
+func nextCloser(name string, labels int) string {
+	return ancestor(name, labels+1)
+}
+
+// ancestor returns the ancestor of name that has l labels.
+func ancestor(name string, l int) string {
+	indx := dns.Split(name)
+	if l <= 0 || len(indx) == 0 {
+		return "."
+	}
+	if l >= len(indx) {
+		return name
+	}
+	return name[indx[len(indx)-l]:]
+}
+
+// canonicalCompare compares the domain names a and b in canonical DNS order
+// (RFC 4034, Section 6.1). It returns -1, 0 or 1.
+func canonicalCompare(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(la) < len(lb):
+		return -1
+	case len(la) > len(lb):
+		return 1
+	}
+	return 0
 }
 
 // NSEC3 Helper
@@ -512,14 +1040,14 @@ func denial3(nsec3 []dns.RR, in *dns.Msg) {
 		// qname should match nsec3, type should not be in bitmap
 		match := nsec3[0].(*dns.NSEC3).Match(qname)
 		if !match {
-			fmt.Printf(";- Denial, owner name does not match qname\n")
-			fmt.Printf(";- Denial, failed authenticated denial of existence proof for no data\n")
+			fmt.Fprintf(stdout, ";- Denial, owner name does not match qname\n")
+			fmt.Fprintf(stdout, ";- Denial, failed authenticated denial of existence proof for no data\n")
 			return
 		}
 		for _, t := range nsec3[0].(*dns.NSEC3).TypeBitMap {
 			if t == qtype {
-				fmt.Printf(";- Denial, found type, %d, in bitmap\n", qtype)
-				fmt.Printf(";- Denial, failed authenticated denial of existence proof for no data\n")
+				fmt.Fprintf(stdout, ";- Denial, found type, %d, in bitmap\n", qtype)
+				fmt.Fprintf(stdout, ";- Denial, failed authenticated denial of existence proof for no data\n")
 				return
 			}
 			if t > qtype { // ordered list, bail out, because not found
@@ -527,10 +1055,10 @@ func denial3(nsec3 []dns.RR, in *dns.Msg) {
 			}
 		}
 		// Some success data printed here
-		fmt.Printf(";+ Denial, matching record, %s, (%s) found and type %s denied\n", qname,
+		fmt.Fprintf(stdout, ";+ Denial, matching record, %s, (%s) found and type %s denied\n", qname,
 			strings.ToLower(dns.HashName(qname, nsec3[0].(*dns.NSEC3).Hash, nsec3[0].(*dns.NSEC3).Iterations, nsec3[0].(*dns.NSEC3).Salt)),
 			dns.TypeToString[qtype])
-		fmt.Printf(";+ Denial, secure authenticated denial of existence proof for no data\n")
+		fmt.Fprintf(stdout, ";+ Denial, secure authenticated denial of existence proof for no data\n")
 		return
 	case dns.RcodeNameError: // NXDOMAIN Proof
 		indx := dns.Split(qname)
@@ -553,30 +1081,30 @@ func denial3(nsec3 []dns.RR, in *dns.Msg) {
 			}
 		}
 		if ce == "" {
-			fmt.Printf(";- Denial, closest encloser not found\n")
+			fmt.Fprintf(stdout, ";- Denial, closest encloser not found\n")
 			return
 		}
-		fmt.Printf(";+ Denial, closest encloser, %s (%s)\n", ce,
+		fmt.Fprintf(stdout, ";+ Denial, closest encloser, %s (%s)\n", ce,
 			strings.ToLower(dns.HashName(ce, nsec3[0].(*dns.NSEC3).Hash, nsec3[0].(*dns.NSEC3).Iterations, nsec3[0].(*dns.NSEC3).Salt)))
 		covered := 0 // Both nc and wc must be covered
 		for i := 0; i < len(nsec3); i++ {
 			if nsec3[i].(*dns.NSEC3).Cover(nc) {
-				fmt.Printf(";+ Denial, next closer %s (%s), covered by %s -> %s\n", nc, nsec3[i].Header().Name, nsec3[i].(*dns.NSEC3).NextDomain,
+				fmt.Fprintf(stdout, ";+ Denial, next closer %s (%s), covered by %s -> %s\n", nc, nsec3[i].Header().Name, nsec3[i].(*dns.NSEC3).NextDomain,
 					strings.ToLower(dns.HashName(ce, nsec3[0].(*dns.NSEC3).Hash, nsec3[0].(*dns.NSEC3).Iterations, nsec3[0].(*dns.NSEC3).Salt)))
 				covered++
 			}
 			if nsec3[i].(*dns.NSEC3).Cover(wc) {
-				fmt.Printf(";+ Denial, source of synthesis %s (%s), covered by %s -> %s\n", wc, nsec3[i].Header().Name, nsec3[i].(*dns.NSEC3).NextDomain,
+				fmt.Fprintf(stdout, ";+ Denial, source of synthesis %s (%s), covered by %s -> %s\n", wc, nsec3[i].Header().Name, nsec3[i].(*dns.NSEC3).NextDomain,
 					strings.ToLower(dns.HashName(ce, nsec3[0].(*dns.NSEC3).Hash, nsec3[0].(*dns.NSEC3).Iterations, nsec3[0].(*dns.NSEC3).Salt)))
 				covered++
 			}
 		}
 		if covered != 2 {
-			fmt.Printf(";- Denial, too many, %d, covering records\n", covered)
-			fmt.Printf(";- Denial, failed authenticated denial of existence proof for name error\n")
+			fmt.Fprintf(stdout, ";- Denial, too many, %d, covering records\n", covered)
+			fmt.Fprintf(stdout, ";- Denial, failed authenticated denial of existence proof for name error\n")
 			return
 		}
-		fmt.Printf(";+ Denial, secure authenticated denial of existence proof for name error\n")
+		fmt.Fprintf(stdout, ";+ Denial, secure authenticated denial of existence proof for name error\n")
 		return
 	}
 }
@@ -592,9 +1120,9 @@ func getRRset(l []dns.RR, name string, t uint16) []dns.RR {
 	return l1
 }
 
-// Get the key from the DNS (uses the local resolver) and return them.
-// If nothing is found we return nil
-func getKey(name string, keytag uint16, server string, tcp bool) *dns.DNSKEY {
+// Get the DNSKEY RRset and its signatures from the DNS (uses the local
+// resolver) and return them. If nothing is found we return nil
+func getKeys(name string, server string, tcp bool) []dns.RR {
 	c := new(dns.Client)
 	if tcp {
 		c.Net = "tcp"
@@ -606,14 +1134,7 @@ func getKey(name string, keytag uint16, server string, tcp bool) *dns.DNSKEY {
 	if err != nil {
 		return nil
 	}
-	for _, k := range r.Answer {
-		if k1, ok := k.(*dns.DNSKEY); ok {
-			if k1.KeyTag() == keytag {
-				return k1
-			}
-		}
-	}
-	return nil
+	return r.Answer
 }
 
 // shortSig shortens RRSIG to "miek.nl RRSIG(NS)"
@@ -639,6 +1160,8 @@ func shortRR(r dns.RR) dns.RR {
 	case *dns.DS:
 		t.Digest = "..."
 	case *dns.DNSKEY:
+		keyTags[t] = t.KeyTag()
+		keyDS[t] = dsRecords(t)
 		t.PublicKey = "..."
 	case *dns.RRSIG:
 		t.Signature = "..."
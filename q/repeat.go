@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// repeatQuery sends m to server *count times, waiting *interval between the
+// queries, and prints a line per reply followed by latency and rcode
+// statistics, much like ping does.
+func repeatQuery(c *dns.Client, m *dns.Msg, server string) {
+	var (
+		rtts   []time.Duration
+		rcodes = map[int]int{}
+	)
+	q := m.Question[0]
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+		m.Id = dns.Id()
+		res := exchange(c, m, server)
+		if res.err != nil {
+			fmt.Fprintf(stdout, ";; %d: %s\n", i+1, res.err.Error())
+			continue
+		}
+		r, rtt := res.r, res.rtt
+		rtts = append(rtts, rtt)
+		rcodes[r.Rcode]++
+		fmt.Fprintf(stdout, ";; %d: %s, %d answers, size: %d bytes, time: %.3d µs\n", i+1, dns.RcodeToString[r.Rcode], len(r.Answer), r.Len(), rtt/1e3)
+	}
+
+	fmt.Fprintf(stdout, "\n;; --- %s %s statistics, server: %s ---\n", q.Name, dns.TypeToString[q.Qtype], server)
+	fmt.Fprintf(stdout, ";; %d queries, %d replies, %.1f%% loss\n", *count, len(rtts), 100*float64(*count-len(rtts))/float64(*count))
+	if len(rtts) == 0 {
+		return
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	var sum time.Duration
+	for _, rtt := range rtts {
+		sum += rtt
+	}
+	p95 := rtts[(len(rtts)*95+99)/100-1]
+	fmt.Fprintf(stdout, ";; rtt min/avg/max/p95 = %.3f/%.3f/%.3f/%.3f ms\n", ms(rtts[0]), ms(sum/time.Duration(len(rtts))), ms(rtts[len(rtts)-1]), ms(p95))
+	fmt.Fprintf(stdout, ";; rcodes:")
+	codes := make([]int, 0, len(rcodes))
+	for rc := range rcodes {
+		codes = append(codes, rc)
+	}
+	sort.Ints(codes)
+	for _, rc := range codes {
+		fmt.Fprintf(stdout, " %s: %d", dns.RcodeToString[rc], rcodes[rc])
+	}
+	fmt.Fprintln(stdout)
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
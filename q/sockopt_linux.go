@@ -0,0 +1,24 @@
+package main
+
+import (
+	"syscall"
+)
+
+// control sets the -tos/-dscp and -mark socket options on the sockets q creates.
+func control(network, address string, c syscall.RawConn) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		if t := tos(); t > 0 {
+			if err = setTOS(int(fd), network, address, t); err != nil {
+				return
+			}
+		}
+		if *mark > 0 {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, *mark)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// control returns an error when -tos, -dscp or -mark is used, these are only
+// implemented on Unix.
+func control(network, address string, c syscall.RawConn) error {
+	if tos() > 0 || *mark > 0 {
+		return errors.New("-tos and -dscp are only supported on Unix, -mark only on Linux")
+	}
+	return nil
+}
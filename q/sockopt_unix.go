@@ -0,0 +1,26 @@
+//go:build unix && !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// control sets the -tos/-dscp socket option on the sockets q creates, -mark is
+// only implemented on Linux.
+func control(network, address string, c syscall.RawConn) error {
+	if *mark > 0 {
+		return errors.New("-mark is only supported on Linux")
+	}
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		if t := tos(); t > 0 {
+			err = setTOS(int(fd), network, address, t)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
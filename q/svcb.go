@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// svcbComment returns a readable summary of the parameters of an SVCB or
+// HTTPS record.
+func svcbComment(s *dns.SVCB) string {
+	if s.Priority == 0 {
+		return " ; alias to " + s.Target
+	}
+	target := s.Target
+	if target == "." {
+		target = s.Hdr.Name
+	}
+	params := []string{fmt.Sprintf("service, priority %d", s.Priority), "target = " + target}
+	for _, v := range s.Value {
+		switch v := v.(type) {
+		case *dns.SVCBAlpn:
+			params = append(params, "alpn = "+strings.Join(v.Alpn, ", "))
+		case *dns.SVCBNoDefaultAlpn:
+			params = append(params, "no default alpn")
+		case *dns.SVCBPort:
+			params = append(params, fmt.Sprintf("port = %d", v.Port))
+		case *dns.SVCBIPv4Hint:
+			params = append(params, "ipv4hint = "+v.String())
+		case *dns.SVCBIPv6Hint:
+			params = append(params, "ipv6hint = "+v.String())
+		case *dns.SVCBECHConfig:
+			params = append(params, fmt.Sprintf("ech = %d bytes", len(v.ECH)))
+		case *dns.SVCBDoHPath:
+			params = append(params, "dohpath = "+v.Template)
+		default:
+			params = append(params, v.Key().String()+" = "+v.String())
+		}
+	}
+	return " ; " + strings.Join(params, "; ")
+}
+
+// chaseSVCB follows the AliasMode SVCB and HTTPS records in the answer of r and
+// looks up the addresses of the targets of the ServiceMode records.
+func chaseSVCB(c *dns.Client, m, r *dns.Msg, servers []string) {
+	qt := m.Question[0].Qtype
+	if qt != dns.TypeSVCB && qt != dns.TypeHTTPS {
+		return
+	}
+	seen := map[string]bool{dns.CanonicalName(m.Question[0].Name): true}
+	for i := 0; i < 8; i++ {
+		alias := ""
+		for _, rr := range r.Answer {
+			if s := svcbRecord(rr); s != nil && s.Priority == 0 {
+				alias = s.Target
+			}
+		}
+		if alias == "" {
+			break
+		}
+		if seen[dns.CanonicalName(alias)] {
+			fmt.Fprintf(stdout, ";- SVCB alias loop at %s\n", alias)
+			return
+		}
+		seen[dns.CanonicalName(alias)] = true
+		fmt.Fprintf(stdout, "\n;; SVCB alias to %s\n", alias)
+		if r = chaseQuery(c, m, alias, qt, servers); r == nil {
+			return
+		}
+	}
+
+	targets := map[string]bool{}
+	for _, rr := range r.Answer {
+		s := svcbRecord(rr)
+		if s == nil || s.Priority == 0 {
+			continue
+		}
+		target := s.Target
+		if target == "." {
+			target = s.Hdr.Name
+		}
+		if targets[dns.CanonicalName(target)] {
+			continue
+		}
+		targets[dns.CanonicalName(target)] = true
+		fmt.Fprintf(stdout, "\n;; SVCB target %s\n", target)
+		chaseQuery(c, m, target, dns.TypeA, servers)
+		chaseQuery(c, m, target, dns.TypeAAAA, servers)
+	}
+}
+
+// chaseQuery asks for name and qtype, with the same settings as m, and prints
+// the answer section.
+func chaseQuery(c *dns.Client, m *dns.Msg, name string, qtype uint16, servers []string) *dns.Msg {
+	mm := m.Copy()
+	mm.Id = dns.Id()
+	mm.Question[0] = dns.Question{Name: name, Qtype: qtype, Qclass: m.Question[0].Qclass}
+	res := queryServers(c, mm, servers)
+	if res.err != nil {
+		fmt.Fprintf(stdout, ";; %s %s: %s\n", name, typeString(qtype), res.err.Error())
+		return nil
+	}
+	if len(res.r.Answer) == 0 {
+		fmt.Fprintf(stdout, ";; %s %s: %s, no answer\n", name, typeString(qtype), dns.RcodeToString[res.r.Rcode])
+	}
+	for _, rr := range res.r.Answer {
+		fmt.Fprintf(stdout, "%s%s\n", rr, rrComment(rr))
+	}
+	return res.r
+}
+
+func svcbRecord(rr dns.RR) *dns.SVCB {
+	switch s := rr.(type) {
+	case *dns.SVCB:
+		return s
+	case *dns.HTTPS:
+		return &s.SVCB
+	}
+	return nil
+}
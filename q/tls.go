@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tlsConfig returns the TLS configuration for DNS over TLS as set with the
+// -tls-* flags and -spki-pin.
+func tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: *tlsName}
+	if *tlsCA != "" {
+		buf, err := os.ReadFile(*tlsCA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("no certificates in %s", *tlsCA)
+		}
+	}
+	if *tlsCert != "" || *tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if *spkiPin != "" {
+		pins := map[string]bool{}
+		for _, p := range strings.Split(*spkiPin, ",") {
+			if !strings.HasPrefix(p, "sha256//") {
+				return nil, fmt.Errorf("pin %q does not start with sha256//", p)
+			}
+			pins[strings.TrimPrefix(p, "sha256//")] = true
+		}
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				if pins[spkiHash(cert)] {
+					return nil
+				}
+			}
+			return fmt.Errorf("no certificate matches -spki-pin, server has sha256//%s", spkiHash(cs.PeerCertificates[0]))
+		}
+	}
+	return config, nil
+}
+
+// spkiHash returns the base64 encoded SHA-256 of the certificate's public key,
+// as used in HPKP pins (RFC 7469) and curl's --pinnedpubkey.
+func spkiHash(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(h[:])
+}
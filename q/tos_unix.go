@@ -0,0 +1,16 @@
+//go:build unix
+
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+// setTOS sets the IP TOS byte, or the traffic class for IPv6, on fd.
+func setTOS(fd int, network, address string, tos int) error {
+	if strings.HasSuffix(network, "6") || (!strings.HasSuffix(network, "4") && strings.HasPrefix(address, "[")) {
+		return syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	}
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+// traceReferrals sends m with RD=0 to server and follows the referrals it gets
+// back, using the glue when there is any. Each step shows the zone cut that is
+// crossed. It stops at an answer, an error or after 16 referrals.
+func traceReferrals(c *dns.Client, m *dns.Msg, server string) {
+	m = m.Copy()
+	m.RecursionDesired = false
+	zone := "."
+	for i := 0; i < 16; i++ {
+		m.Id = dns.Id()
+		res := exchange(c, m, server)
+		if res.err != nil {
+			fmt.Fprintf(stdout, ";; %s: %s\n", server, res.err.Error())
+			return
+		}
+		r := res.r
+		fmt.Fprintf(stdout, ";; %s from %s (zone %s): %s, %.3f ms\n", m.Question[0].Name, server, zone, dns.RcodeToString[r.Rcode], ms(res.rtt))
+		if len(r.Answer) > 0 || r.Rcode != dns.RcodeSuccess || r.Authoritative {
+			printMsg(res)
+			return
+		}
+
+		var ns []string
+		cut := ""
+		for _, rr := range r.Ns {
+			if n, ok := rr.(*dns.NS); ok {
+				ns = append(ns, n.Ns)
+				cut = n.Hdr.Name
+			}
+		}
+		if len(ns) == 0 {
+			fmt.Fprintf(stdout, ";- No answer and no referral from %s\n", server)
+			printMsg(res)
+			return
+		}
+		if !dns.IsSubDomain(zone, cut) || dns.CanonicalName(zone) == dns.CanonicalName(cut) {
+			fmt.Fprintf(stdout, ";- Referral to %s is not below %s, lame or upward referral\n", cut, zone)
+			return
+		}
+		for _, rr := range r.Ns {
+			fmt.Fprintf(stdout, "%s\n", rr)
+		}
+
+		next := ""
+		for _, n := range ns {
+			for _, rr := range r.Extra {
+				if !dns.IsSubDomain(cut, rr.Header().Name) || dns.CanonicalName(rr.Header().Name) != dns.CanonicalName(n) {
+					continue
+				}
+				switch a := rr.(type) {
+				case *dns.A:
+					if !*six {
+						next = a.A.String()
+					}
+				case *dns.AAAA:
+					if !*four {
+						next = a.AAAA.String()
+					}
+				}
+				if next != "" {
+					fmt.Fprintf(stdout, "%s\n", rr)
+					break
+				}
+			}
+			if next != "" {
+				break
+			}
+		}
+		if next == "" {
+			// No usable glue, resolve the first name server with the system resolver.
+			addrs, err := net.LookupHost(ns[0])
+			if err != nil || len(addrs) == 0 {
+				fmt.Fprintf(stdout, ";- No glue and no address for %s\n", ns[0])
+				return
+			}
+			next = addrs[0]
+			fmt.Fprintf(stdout, ";; %s has address %s (no glue)\n", ns[0], next)
+		}
+		fmt.Fprintf(stdout, ";; Delegation %s -> %s\n\n", zone, cut)
+		zone = cut
+		server = net.JoinHostPort(next, strconv.Itoa(*port))
+	}
+	fmt.Fprintf(stdout, ";- Too many referrals\n")
+}
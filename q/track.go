@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Trust anchor states from RFC 5011, Section 4.
+const (
+	stateAddPend = "AddPend"
+	stateValid   = "Valid"
+	stateMissing = "Missing"
+	stateRevoked = "Revoked"
+)
+
+// holdDown is the add and remove hold-down time, RFC 5011, Section 2.4.1.
+const holdDown = 30 * 24 * time.Hour
+
+// trackedKey is a key in the anchor state file.
+type trackedKey struct {
+	state string
+	since time.Time // when the key entered state
+	key   *dns.DNSKEY
+}
+
+// trackAnchor fetches the DNSKEY RRset of zone and updates the trust anchor
+// state kept in file as an RFC 5011 resolver would. Transitions are printed.
+func trackAnchor(c *dns.Client, zone, file string, servers []string) {
+	keys, err := readTrackState(file)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Failure to read anchor state from %s: %s\n", file, err.Error())
+		return
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeDNSKEY)
+	m.SetEdns0(4096, true)
+	res := queryServers(c, m, servers)
+	if res.err != nil {
+		fmt.Fprintf(stdout, ";; %s\n", res.err.Error())
+		return
+	}
+	set := getRRset(res.r.Answer, zone, dns.TypeDNSKEY)
+	if len(set) == 0 {
+		fmt.Fprintf(stdout, ";- No DNSKEY records for %s from %s\n", zone, res.server)
+		return
+	}
+	now := time.Now().UTC()
+
+	if len(keys) == 0 {
+		// Nothing known yet, take the SEP keys as the initial trust anchors.
+		for _, rr := range set {
+			k := rr.(*dns.DNSKEY)
+			if k.Flags&dns.SEP == 0 || k.Flags&dns.REVOKE != 0 {
+				continue
+			}
+			keys = append(keys, &trackedKey{state: stateValid, since: now, key: k})
+			fmt.Fprintf(stdout, ";+ DNSKEY %s/%d: %s (initial)\n", zone, k.KeyTag(), stateValid)
+		}
+		if err := writeTrackState(file, keys); err != nil {
+			fmt.Fprintf(os.Stderr, "Failure to write anchor state to %s: %s\n", file, err.Error())
+		}
+		return
+	}
+
+	if !trackSigned(res.r.Answer, set, keys) {
+		fmt.Fprintf(stdout, ";- DNSKEY %s RRset is not signed by a trusted key, state not updated\n", zone)
+		return
+	}
+
+	seen := map[*trackedKey]bool{}
+	for _, rr := range set {
+		k := rr.(*dns.DNSKEY)
+		t := trackFind(keys, k)
+		if t == nil {
+			if k.Flags&dns.SEP == 0 || k.Flags&dns.REVOKE != 0 {
+				continue
+			}
+			t = &trackedKey{state: stateAddPend, since: now, key: k}
+			keys = append(keys, t)
+			seen[t] = true
+			fmt.Fprintf(stdout, ";? DNSKEY %s/%d: new key, %s until %s\n", zone, k.KeyTag(), stateAddPend, now.Add(holdDown).Format(time.RFC3339))
+			continue
+		}
+		seen[t] = true
+		switch {
+		case k.Flags&dns.REVOKE != 0 && t.state != stateRevoked && selfSigned(res.r.Answer, set, k):
+			trackMove(zone, t, stateRevoked, now)
+			t.key = k
+		case t.state == stateAddPend && now.Sub(t.since) >= holdDown:
+			trackMove(zone, t, stateValid, now)
+		case t.state == stateMissing:
+			trackMove(zone, t, stateValid, now)
+		default:
+			fmt.Fprintf(stdout, ";; DNSKEY %s/%d: %s since %s\n", zone, t.key.KeyTag(), t.state, t.since.Format(time.RFC3339))
+		}
+	}
+
+	var keep []*trackedKey
+	for _, t := range keys {
+		switch {
+		case seen[t]:
+		case t.state == stateAddPend:
+			fmt.Fprintf(stdout, ";- DNSKEY %s/%d: removed before the hold-down expired\n", zone, t.key.KeyTag())
+			continue
+		case t.state == stateValid:
+			trackMove(zone, t, stateMissing, now)
+		case t.state == stateRevoked && now.Sub(t.since) >= holdDown:
+			fmt.Fprintf(stdout, ";- DNSKEY %s/%d: removed\n", zone, t.key.KeyTag())
+			continue
+		}
+		keep = append(keep, t)
+	}
+	if err := writeTrackState(file, keep); err != nil {
+		fmt.Fprintf(os.Stderr, "Failure to write anchor state to %s: %s\n", file, err.Error())
+	}
+}
+
+func trackMove(zone string, t *trackedKey, state string, now time.Time) {
+	fmt.Fprintf(stdout, ";+ DNSKEY %s/%d: %s -> %s\n", zone, t.key.KeyTag(), t.state, state)
+	t.state = state
+	t.since = now
+}
+
+// trackFind returns the tracked key that has the same public key as k, the
+// flags are not compared because the REVOKE bit changes them.
+func trackFind(keys []*trackedKey, k *dns.DNSKEY) *trackedKey {
+	for _, t := range keys {
+		if t.key.Algorithm == k.Algorithm && t.key.PublicKey == k.PublicKey {
+			return t
+		}
+	}
+	return nil
+}
+
+// trackSigned returns true when the DNSKEY RRset is signed by a key that is
+// a valid or missing trust anchor.
+func trackSigned(answer, set []dns.RR, keys []*trackedKey) bool {
+	for _, t := range keys {
+		if t.state != stateValid && t.state != stateMissing {
+			continue
+		}
+		for _, rr := range set {
+			if k := rr.(*dns.DNSKEY); k.PublicKey == t.key.PublicKey && selfSigned(answer, set, k) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selfSigned returns true when k has a valid signature over the DNSKEY RRset.
+func selfSigned(answer, set []dns.RR, k *dns.DNSKEY) bool {
+	for _, rr := range answer {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok || sig.TypeCovered != dns.TypeDNSKEY || sig.KeyTag != k.KeyTag() {
+			continue
+		}
+		if sig.Verify(k, set) == nil && sig.ValidityPeriod(time.Now().UTC()) {
+			return true
+		}
+	}
+	return false
+}
+
+// readTrackState reads the state file, each line has the state, the time the
+// key entered that state (seconds since the epoch) and the DNSKEY.
+func readTrackState(file string) ([]*trackedKey, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []*trackedKey
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed line: %q", line)
+		}
+		since, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		rr, err := dns.NewRR(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		k, ok := rr.(*dns.DNSKEY)
+		if !ok {
+			return nil, fmt.Errorf("not a DNSKEY: %q", fields[2])
+		}
+		keys = append(keys, &trackedKey{state: fields[0], since: time.Unix(since, 0).UTC(), key: k})
+	}
+	return keys, scanner.Err()
+}
+
+func writeTrackState(file string, keys []*trackedKey) error {
+	var b strings.Builder
+	b.WriteString("; RFC 5011 trust anchor state, written by q -track-anchor\n")
+	for _, t := range keys {
+		fmt.Fprintf(&b, "%s %d %s\n", t.state, t.since.Unix(), t.key.String())
+	}
+	return os.WriteFile(file, []byte(b.String()), 0644)
+}
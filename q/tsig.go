@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// tsigAlgorithms maps the algorithm numbers used in K*.private files to the
+// names used by -tsig.
+var tsigAlgorithms = map[string]string{
+	"157": "hmac-md5",
+	"161": "hmac-sha1",
+	"162": "hmac-sha224",
+	"163": "hmac-sha256",
+	"164": "hmac-sha384",
+	"165": "hmac-sha512",
+}
+
+var (
+	keyName      = regexp.MustCompile(`key\s+"?([^"\s{]+)"?\s*{`)
+	keyAlgorithm = regexp.MustCompile(`algorithm\s+"?([^";\s]+)"?\s*;`)
+	keySecret    = regexp.MustCompile(`secret\s+"([^"]+)"\s*;`)
+)
+
+// tsigKeyFile reads a TSIG key from a BIND key file (key "name" { ... };) or
+// from a K*.private file and returns it in the -tsig format.
+func tsigKeyFile(file string) (string, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	s := string(buf)
+
+	if strings.HasPrefix(s, "Private-key-format:") {
+		// Kname.+alg+id.private, the name is in the file name.
+		base := filepath.Base(file)
+		i := strings.Index(base, ".+")
+		if !strings.HasPrefix(base, "K") || i < 0 {
+			return "", fmt.Errorf("can not find the key name in %s", base)
+		}
+		var algo, secret string
+		for _, l := range strings.Split(s, "\n") {
+			k, v, ok := strings.Cut(l, ":")
+			if !ok {
+				continue
+			}
+			v = strings.TrimSpace(v)
+			switch k {
+			case "Algorithm":
+				if f := strings.Fields(v); len(f) > 0 {
+					algo = tsigAlgorithms[f[0]]
+				}
+			case "Key":
+				secret = v
+			}
+		}
+		if algo == "" || secret == "" {
+			return "", fmt.Errorf("no HMAC algorithm or key in %s", file)
+		}
+		return algo + ":" + base[1:i] + ":" + secret, nil
+	}
+
+	name := keyName.FindStringSubmatch(s)
+	algo := keyAlgorithm.FindStringSubmatch(s)
+	secret := keySecret.FindStringSubmatch(s)
+	if name == nil || algo == nil || secret == nil {
+		return "", fmt.Errorf("no key statement with algorithm and secret in %s", file)
+	}
+	// BIND writes HMAC-MD5 with its full name, hmac-md5.sig-alg.reg.int.
+	a := strings.TrimSuffix(strings.ToLower(strings.TrimSuffix(algo[1], ".")), ".sig-alg.reg.int")
+	return a + ":" + name[1] + ":" + secret[1], nil
+}
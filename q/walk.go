@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// walkZone enumerates zone by following the NextDomain of the NSEC records,
+// starting at the apex or at from. Every owner name is printed with the types
+// in its bitmap. At most rate queries per second are sent.
+func walkZone(c *dns.Client, zone, from string, rate int, servers []string) {
+	zone = dns.Fqdn(zone)
+	name := zone
+	if from != "" {
+		name = dns.Fqdn(from)
+	}
+	var tick <-chan time.Time
+	if rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	seen := map[string]bool{}
+	names := 0
+	for {
+		if tick != nil {
+			<-tick
+		}
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeNSEC)
+		m.SetEdns0(4096, true)
+		res := queryServers(c, m, servers)
+		if res.err != nil {
+			fmt.Fprintf(stdout, ";; %s: %s\n;; resume with -walk-from %s\n", name, res.err.Error(), name)
+			return
+		}
+		var nsec []dns.RR
+		for _, rr := range append(res.r.Answer, res.r.Ns...) {
+			if rr.Header().Rrtype == dns.TypeNSEC {
+				nsec = append(nsec, rr)
+			}
+		}
+		var n *dns.NSEC
+		for _, rr := range nsec {
+			if dns.CanonicalName(rr.Header().Name) == dns.CanonicalName(name) {
+				n = rr.(*dns.NSEC)
+			}
+		}
+		if n == nil {
+			// name does not exist (when resuming), continue from the NSEC
+			// that covers it.
+			n = nsecCover(nsec, name)
+		}
+		if n == nil {
+			fmt.Fprintf(stdout, ";- No NSEC record for %s, is %s signed with NSEC?\n;; resume with -walk-from %s\n", name, zone, name)
+			return
+		}
+
+		if !seen[dns.CanonicalName(n.Hdr.Name)] {
+			types := make([]string, len(n.TypeBitMap))
+			for i, t := range n.TypeBitMap {
+				types[i] = typeString(t)
+			}
+			fmt.Fprintf(stdout, "%s\t%s\n", n.Hdr.Name, strings.Join(types, " "))
+			names++
+		}
+		seen[dns.CanonicalName(n.Hdr.Name)] = true
+
+		next := n.NextDomain
+		if dns.CanonicalName(next) == dns.CanonicalName(zone) {
+			break
+		}
+		if seen[dns.CanonicalName(next)] || !dns.IsSubDomain(zone, next) {
+			fmt.Fprintf(stdout, ";- NSEC chain loops or leaves the zone at %s, the server might synthesize NSEC records\n", next)
+			return
+		}
+		name = next
+	}
+	fmt.Fprintf(stdout, "\n;; %s: %d names\n", zone, names)
+}
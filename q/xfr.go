@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// ixfrStep is a single difference sequence in an incremental zone transfer:
+// the records deleted from the zone at serial from and the records added to
+// get the zone to serial to.
+type ixfrStep struct {
+	from, to *dns.SOA
+	del, add []dns.RR
+}
+
+// printIxfr prints the records of an IXFR response. An incremental response
+// (RFC 1995) is shown as a diff, with "-" for removed and "+" for added
+// records, for each serial step. When the server fell back to a full zone
+// transfer the records are printed as-is.
+func printIxfr(rrs []dns.RR) {
+	if len(rrs) == 0 {
+		return
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		for _, r := range rrs {
+			fmt.Fprintf(stdout, "%s\n", r)
+		}
+		return
+	}
+	if len(rrs) == 1 {
+		fmt.Fprintf(stdout, "%s\n", soa)
+		fmt.Fprintf(stdout, ";; IXFR up to date at serial %d\n", soa.Serial)
+		return
+	}
+	if _, ok := rrs[1].(*dns.SOA); !ok {
+		fmt.Fprintf(stdout, ";; IXFR answered with a full zone transfer\n")
+		for _, r := range rrs {
+			fmt.Fprintf(stdout, "%s\n", r)
+		}
+		return
+	}
+
+	steps := ixfrSteps(rrs[1 : len(rrs)-1])
+	fmt.Fprintf(stdout, "%s\n", soa)
+	if len(steps) == 0 {
+		fmt.Fprintf(stdout, ";; IXFR without difference sequences\n")
+		return
+	}
+	fmt.Fprintf(stdout, ";; IXFR from serial %d to serial %d in %d steps\n", steps[0].from.Serial, soa.Serial, len(steps))
+	for _, s := range steps {
+		fmt.Fprintf(stdout, "\n;; serial %d -> %d\n", s.from.Serial, s.to.Serial)
+		for _, r := range s.del {
+			fmt.Fprintf(stdout, "-%s\n", r)
+		}
+		for _, r := range s.add {
+			fmt.Fprintf(stdout, "+%s\n", r)
+		}
+	}
+}
+
+// ixfrSteps splits the difference sequences of an IXFR response, rrs is the
+// response without the leading and trailing SOA. Each SOA in rrs starts a
+// new deletion or addition.
+func ixfrSteps(rrs []dns.RR) []*ixfrStep {
+	var (
+		steps []*ixfrStep
+		s     *ixfrStep
+		del   bool
+	)
+	for _, r := range rrs {
+		if soa, ok := r.(*dns.SOA); ok {
+			del = !del
+			if del {
+				s = &ixfrStep{from: soa}
+				steps = append(steps, s)
+			} else {
+				s.to = soa
+			}
+			continue
+		}
+		if del {
+			s.del = append(s.del, r)
+		} else {
+			s.add = append(s.add, r)
+		}
+	}
+	// A malformed response may end in the middle of a step.
+	if s != nil && s.to == nil {
+		s.to = s.from
+	}
+	return steps
+}
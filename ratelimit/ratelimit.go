@@ -0,0 +1,146 @@
+// Package ratelimit implements response rate limiting (RRL) for DNS servers.
+// Clients are grouped by prefix and each prefix gets a token bucket, when it is
+// empty the response is dropped, except for every Nth one which is "slipped":
+// sent truncated, so a real client retries over TCP. With Leak set a small
+// fraction of the limited responses is still sent in full, so the victim of a
+// spoofed flood is not cut off completely.
+//
+// The buckets are kept in an LRU of at most Size prefixes, when it is full the
+// least recently seen prefix is forgotten.
+//
+// Only UDP responses should be limited, a TCP client can't spoof its address.
+package ratelimit
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"time"
+)
+
+// Action is what to do with a response.
+type Action int
+
+const (
+	Send Action = iota // send the response
+	Drop               // drop the response
+	Slip               // send a truncated response, without records
+)
+
+func (a Action) String() string {
+	switch a {
+	case Send:
+		return "send"
+	case Drop:
+		return "drop"
+	case Slip:
+		return "slip"
+	}
+	return "unknown"
+}
+
+// The default prefix lengths used to group the clients, limiting single
+// addresses is evaded by spoofing addresses from the same network.
+const (
+	DefaultPrefix4 = 24
+	DefaultPrefix6 = 56
+	DefaultSize    = 100000
+)
+
+// bucket is the token bucket of one prefix.
+type bucket struct {
+	key     string
+	tokens  float64
+	last    time.Time
+	limited int // number of limited responses, for slip
+}
+
+// Limiter limits the responses per prefix.
+type Limiter struct {
+	// Leak makes every Leak-th limited response be sent anyway, 0 sends none.
+	// It must be set before Limit is used.
+	Leak int
+	// Prefix4 and Prefix6 are the prefix lengths the IPv4 and IPv6 clients
+	// are grouped by. New sets them to DefaultPrefix4 and DefaultPrefix6,
+	// values out of range are clamped to 0-32 and 0-128.
+	Prefix4, Prefix6 int
+	// Size is the maximum number of prefixes remembered, New sets it to
+	// DefaultSize.
+	Size int
+
+	rate  float64 // responses per second
+	burst float64
+	slip  int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List // front is the most recently seen
+}
+
+// New returns a Limiter that allows rate responses per second per prefix, with
+// bursts of up to burst responses. Every slip-th limited response is slipped,
+// with 0 they are all dropped and with 1 all are slipped.
+func New(rate, burst, slip int) *Limiter {
+	return &Limiter{
+		rate:    float64(rate),
+		burst:   float64(max(burst, 1)),
+		slip:    slip,
+		Prefix4: DefaultPrefix4,
+		Prefix6: DefaultPrefix6,
+		Size:    DefaultSize,
+		buckets: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Limit returns what to do with a response to ip at now.
+func (l *Limiter) Limit(ip net.IP, now time.Time) Action {
+	if l.rate <= 0 {
+		return Send
+	}
+	key := l.prefix(ip)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucket(key, now)
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return Send
+	}
+	b.limited++
+	if l.Leak > 0 && b.limited%l.Leak == 0 {
+		return Send
+	}
+	if l.slip > 0 && b.limited%l.slip == 0 {
+		return Slip
+	}
+	return Drop
+}
+
+// bucket returns the bucket for key and makes it the most recently seen. A new
+// bucket starts full, if that makes the LRU too large the least recently seen
+// bucket is evicted.
+func (l *Limiter) bucket(key string, now time.Time) *bucket {
+	if e, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(e)
+		return e.Value.(*bucket)
+	}
+	for l.lru.Len() >= max(l.Size, 1) {
+		e := l.lru.Back()
+		l.lru.Remove(e)
+		delete(l.buckets, e.Value.(*bucket).key)
+	}
+	b := &bucket{key: key, tokens: l.burst, last: now}
+	l.buckets[key] = l.lru.PushFront(b)
+	return b
+}
+
+// prefix returns the prefix of ip that is limited as one client.
+func (l *Limiter) prefix(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(min(max(l.Prefix4, 0), 32), 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(min(max(l.Prefix6, 0), 128), 128)).String()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/miekg/exdns/ratelimit"
+)
+
+// prefixes is the list of networks given with -allow, it can be repeated.
+type prefixes []*net.IPNet
+
+func (p *prefixes) String() string {
+	s := make([]string, len(*p))
+	for i := range *p {
+		s[i] = (*p)[i].String()
+	}
+	return strings.Join(s, ",")
+}
+
+func (p *prefixes) Set(s string) error {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return fmt.Errorf("invalid prefix: %q", s)
+	}
+	*p = append(*p, n)
+	return nil
+}
+
+func (p prefixes) contains(ip net.IP) bool {
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// guarded wraps h so that clients not in -allow, and UDP clients that exceed
+// -rate in limit, are refused, or get no reply with -drop. TCP clients can't
+// spoof their address so they are not limited.
+func guarded(limit *ratelimit.Limiter, h dns.HandlerFunc) dns.HandlerFunc {
+	if len(allow) == 0 && *rate == 0 {
+		return h
+	}
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		var ip net.IP
+		udp := false
+		switch a := w.RemoteAddr().(type) {
+		case *net.UDPAddr:
+			ip, udp = a.IP, true
+		case *net.TCPAddr:
+			ip = a.IP
+		}
+		ok := len(allow) == 0 || allow.contains(ip)
+		if ok && udp && *rate > 0 {
+			ok = limit.Limit(ip, time.Now()) == ratelimit.Send
+		}
+		if ok {
+			h(w, r)
+			return
+		}
+		if *drop {
+			return
+		}
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+	}
+}
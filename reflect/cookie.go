@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"time"
+)
+
+// cookieSecret is used to make the server cookies, a new one is made on every
+// start so the cookies of a previous run are no longer valid.
+var cookieSecret = func() []byte {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return b
+}()
+
+// cookieLifetime is how long a server cookie is valid, see RFC 9018.
+const cookieLifetime = time.Hour
+
+// checkCookie splits the hex encoded COOKIE option of a query into the client
+// cookie and the server cookie and checks the latter was made by us for ip. It
+// returns an error for a malformed option, RFC 7873 says that is a FORMERR.
+func checkCookie(cookie string, ip net.IP, now time.Time) (client string, valid bool, err error) {
+	b, err := hex.DecodeString(cookie)
+	if err != nil {
+		return "", false, err
+	}
+	if len(b) != 8 && (len(b) < 16 || len(b) > 40) {
+		return "", false, errors.New("bad cookie length")
+	}
+	client = cookie[:16]
+	if len(b) != 24 || b[8] != 1 { // only our own version 1 cookies can be valid
+		return client, false, nil
+	}
+	ts := time.Unix(int64(binary.BigEndian.Uint32(b[12:16])), 0)
+	if now.Sub(ts) > cookieLifetime || ts.Sub(now) > 5*time.Minute {
+		return client, false, nil
+	}
+	return client, hmac.Equal(b[8:], serverCookie(b[:8], ip, ts)), nil
+}
+
+// serverCookie returns a server cookie for client and ip in the RFC 9018
+// layout: version, reserved, timestamp and a hash, HMAC-SHA256 is used for
+// the hash instead of SipHash.
+func serverCookie(client []byte, ip net.IP, now time.Time) []byte {
+	sc := []byte{1, 0, 0, 0}
+	sc = binary.BigEndian.AppendUint32(sc, uint32(now.Unix()))
+	h := hmac.New(sha256.New, cookieSecret)
+	h.Write(client)
+	h.Write(sc)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	h.Write(ip)
+	return h.Sum(sc)[:16]
+}
+
+// newCookie returns the hex encoded COOKIE option for the reply to client.
+func newCookie(client string, ip net.IP, now time.Time) string {
+	b, _ := hex.DecodeString(client)
+	return client + hex.EncodeToString(serverCookie(b, ip, now))
+}
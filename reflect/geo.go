@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// geoDBs are the databases given with -geodb.
+var geoDBs []*mmdb
+
+// files is a list of file names, the flag can be repeated.
+type files []string
+
+func (f *files) String() string     { return strings.Join(*f, ",") }
+func (f *files) Set(s string) error { *f = append(*f, s); return nil }
+
+// geoInfo returns the ASN, AS name and country of ip found in the -geodb
+// databases. Both the MaxMind GeoLite2 ASN and Country/City layouts and the
+// ipinfo layout are understood.
+func geoInfo(ip net.IP) []string {
+	var asn, org, country string
+	for _, db := range geoDBs {
+		m, err := db.lookup(ip)
+		if err != nil || m == nil {
+			continue
+		}
+		if n, ok := m["autonomous_system_number"].(uint64); ok {
+			asn = fmt.Sprintf("%d", n)
+		}
+		if s, ok := m["autonomous_system_organization"].(string); ok {
+			org = s
+		}
+		for _, key := range []string{"country", "registered_country"} {
+			if c, ok := m[key].(map[string]interface{}); ok && country == "" {
+				country, _ = c["iso_code"].(string)
+			}
+		}
+		// ipinfo
+		if s, ok := m["asn"].(string); ok {
+			asn = strings.TrimPrefix(s, "AS")
+		}
+		if s, ok := m["as_name"].(string); ok {
+			org = s
+		}
+		if s, ok := m["country"].(string); ok {
+			country = s
+		}
+	}
+	var txt []string
+	if asn != "" {
+		if org != "" {
+			asn += " (" + org + ")"
+		}
+		txt = append(txt, "ASN: "+asn)
+	}
+	if country != "" {
+		txt = append(txt, "Country: "+country)
+	}
+	return txt
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/miekg/exdns/dnstap"
+)
+
+// queryLog is a line written with -log json.
+type queryLog struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Transport string    `json:"transport"`
+	Qname     string    `json:"qname"`
+	Qtype     string    `json:"qtype"`
+	Bufsize   uint16    `json:"bufsize,omitempty"`
+	DO        bool      `json:"do"`
+	Rcode     string    `json:"rcode,omitempty"`
+}
+
+var jsonLog = json.NewEncoder(os.Stdout)
+
+// recorder is a dns.ResponseWriter that keeps the last reply written.
+type recorder struct {
+	dns.ResponseWriter
+	reply []byte
+	rt    time.Time
+}
+
+func (r *recorder) WriteMsg(m *dns.Msg) error {
+	if buf, err := m.Pack(); err == nil {
+		r.reply, r.rt = buf, time.Now()
+	}
+	return r.ResponseWriter.WriteMsg(m)
+}
+
+func (r *recorder) Write(buf []byte) (int, error) {
+	r.reply, r.rt = append([]byte(nil), buf...), time.Now()
+	return r.ResponseWriter.Write(buf)
+}
+
+// tap is used to log the queries and replies with -dnstap.
+var tap *dnstap.Writer
+
+// logged wraps h so every query is logged with -log and -dnstap.
+func logged(h dns.HandlerFunc) dns.HandlerFunc {
+	if *logf == "" && tap == nil {
+		return h
+	}
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		qt := time.Now()
+		rec := &recorder{ResponseWriter: w}
+		h(rec, r)
+
+		if tap != nil {
+			proto := dnstap.UDP
+			if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+				proto = dnstap.TCP
+			}
+			query, _ := r.Pack()
+			tap.Log(dnstap.AuthQuery, w.RemoteAddr(), w.LocalAddr(), proto, query, qt, rec.reply, rec.rt)
+		}
+		if *logf == "json" && len(r.Question) > 0 {
+			l := queryLog{
+				Time:      qt.UTC(),
+				Client:    w.RemoteAddr().String(),
+				Transport: "udp",
+				Qname:     r.Question[0].Name,
+				Qtype:     dns.Type(r.Question[0].Qtype).String(),
+			}
+			if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+				l.Transport = "tcp"
+			}
+			if opt := r.IsEdns0(); opt != nil {
+				l.Bufsize, l.DO = opt.UDPSize(), opt.Do()
+			}
+			if rec.reply != nil {
+				reply := new(dns.Msg)
+				if reply.Unpack(rec.reply) == nil {
+					l.Rcode = dns.RcodeToString[reply.Rcode]
+				}
+			}
+			if err := jsonLog.Encode(l); err != nil {
+				log.Printf("Failed to log query: %s", err)
+			}
+		}
+	}
+}
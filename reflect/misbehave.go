@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// behavior is how a test name given with -test misbehaves.
+type behavior struct {
+	kind  string        // tc, half, delay, formerr or rcode
+	delay time.Duration // for delay
+	rcode int           // for rcode, -1 is a random one
+}
+
+// behaviors are the test names below the domain and how they misbehave, the
+// flag can be repeated. These are the defaults, tc is the original truncation
+// test which sends half a message.
+type behaviors map[string]behavior
+
+var tests = behaviors{
+	"tc":       {kind: "half"},
+	"truncate": {kind: "tc"},
+	"slow":     {kind: "delay", delay: 2 * time.Second},
+	"formerr":  {kind: "formerr"},
+	"rcode":    {kind: "rcode", rcode: -1},
+}
+
+func (b behaviors) String() string {
+	s := make([]string, 0, len(b))
+	for name, t := range b {
+		s = append(s, name+"="+t.kind)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+// Set parses name=behavior, where behavior is one of:
+//
+//	tc            always set TC and send an empty answer, also over TCP
+//	half          set TC and send only the first half of the message
+//	delay[:2s]    answer after a delay, the default is 2s
+//	formerr       reply with FORMERR
+//	rcode[:NAME]  reply with the rcode NAME, without it a random one is used
+func (b behaviors) Set(s string) error {
+	name, kind, ok := strings.Cut(s, "=")
+	if !ok || name == "" || strings.Contains(name, ".") {
+		return fmt.Errorf("invalid test: %q", s)
+	}
+	kind, arg, _ := strings.Cut(kind, ":")
+	t := behavior{kind: kind}
+	switch kind {
+	case "tc", "half", "formerr":
+	case "delay":
+		t.delay = 2 * time.Second
+		if arg != "" {
+			d, err := time.ParseDuration(arg)
+			if err != nil {
+				return fmt.Errorf("invalid delay: %q", arg)
+			}
+			t.delay = d
+		}
+	case "rcode":
+		t.rcode = -1
+		if arg != "" {
+			rc, ok := dns.StringToRcode[strings.ToUpper(arg)]
+			if !ok || rc > 0xF {
+				return fmt.Errorf("invalid rcode: %q", arg)
+			}
+			t.rcode = rc
+		}
+	default:
+		return fmt.Errorf("unknown test behavior: %q", kind)
+	}
+	b[strings.ToLower(name)] = t
+	return nil
+}
+
+// misbehave changes the reply m for the test t, the half message is sent by
+// the caller.
+func (t behavior) misbehave(m *dns.Msg) {
+	switch t.kind {
+	case "tc":
+		m.Truncated = true
+		empty(m)
+	case "half":
+		m.Truncated = true
+	case "delay":
+		time.Sleep(t.delay)
+	case "formerr":
+		m.Rcode = dns.RcodeFormatError
+		empty(m)
+	case "rcode":
+		m.Rcode = t.rcode
+		if m.Rcode < 0 {
+			m.Rcode = dns.RcodeFormatError + rand.Intn(dns.RcodeNotZone) // FORMERR up to NOTZONE
+		}
+		if m.Rcode != dns.RcodeSuccess {
+			empty(m)
+		}
+	}
+}
+
+// empty removes all records from m, except the OPT record.
+func empty(m *dns.Msg) {
+	o := m.IsEdns0()
+	m.Answer, m.Ns, m.Extra = nil, nil, nil
+	if o != nil {
+		m.Extra = append(m.Extra, o)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// mmdb is a MaxMind DB file, the format used by the MaxMind GeoLite2 and
+// ipinfo databases. Only what is needed to look up an address is
+// implemented, see https://maxmind.github.io/MaxMind-DB/.
+type mmdb struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	data       uint // offset of the data section
+	ipv4Start  uint // node where the IPv4 addresses start in an IPv6 tree
+}
+
+var metadataStart = []byte("\xab\xcd\xefMaxMind.com")
+
+// openMMDB reads the database in file.
+func openMMDB(file string) (*mmdb, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(buf, metadataStart)
+	if i < 0 {
+		return nil, errors.New("not a MaxMind DB file")
+	}
+	d := &mmdb{buf: buf}
+	meta, _, err := d.decode(uint(i+len(metadataStart)), uint(i+len(metadataStart)))
+	if err != nil {
+		return nil, fmt.Errorf("bad metadata: %s", err)
+	}
+	m, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("bad metadata")
+	}
+	d.nodeCount, d.recordSize, d.ipVersion = toUint(m["node_count"]), toUint(m["record_size"]), toUint(m["ip_version"])
+	switch d.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %d", d.recordSize)
+	}
+	d.data = d.nodeCount*d.recordSize/4 + 16
+	if d.data > uint(i) {
+		return nil, errors.New("search tree is larger than the file")
+	}
+	if d.ipVersion == 6 {
+		node := uint(0)
+		for j := 0; j < 96 && node < d.nodeCount; j++ {
+			node = d.record(node, 0)
+		}
+		d.ipv4Start = node
+	}
+	return d, nil
+}
+
+// lookup returns the data for ip, or nil when it's not in the database.
+func (d *mmdb) lookup(ip net.IP) (map[string]interface{}, error) {
+	node := uint(0)
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+		if d.ipVersion == 6 {
+			node = d.ipv4Start
+		}
+	} else if d.ipVersion == 4 {
+		return nil, nil
+	}
+	for i := 0; i < bits && node < d.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-uint(i%8))) & 1
+		node = d.record(node, bit)
+	}
+	if node <= d.nodeCount {
+		return nil, nil
+	}
+	offset := node - d.nodeCount - 16 + d.data
+	if offset >= uint(len(d.buf)) {
+		return nil, errors.New("invalid data pointer in search tree")
+	}
+	v, _, err := d.decode(offset, d.data)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := v.(map[string]interface{})
+	return m, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (d *mmdb) record(node, bit uint) uint {
+	b := d.buf[node*d.recordSize/4:]
+	switch d.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	}
+	return uint(binary.BigEndian.Uint32(b[bit*4:]))
+}
+
+// decode decodes the value at offset, pointers are relative to base. It
+// returns the value and the offset of the next one.
+func (d *mmdb) decode(offset, base uint) (interface{}, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errors.New("offset out of range")
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+	if typ == 1 { // pointer
+		n := uint(ctrl>>3) & 3
+		if offset+n+1 > uint(len(d.buf)) {
+			return nil, 0, errors.New("pointer out of range")
+		}
+		b := d.buf[offset : offset+n+1]
+		var p uint
+		switch n {
+		case 0:
+			p = uint(ctrl&7)<<8 | uint(b[0])
+		case 1:
+			p = (uint(ctrl&7)<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			p = (uint(ctrl&7)<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		case 3:
+			p = uint(binary.BigEndian.Uint32(b))
+		}
+		if base+p < uint(len(d.buf)) && d.buf[base+p]>>5 == 1 {
+			return nil, 0, errors.New("pointer to a pointer")
+		}
+		v, _, err := d.decode(base+p, base)
+		return v, offset + n + 1, err
+	}
+	if typ == 0 { // extended
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errors.New("offset out of range")
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return nil, 0, errors.New("size out of range")
+		}
+		b := d.buf[offset : offset+n]
+		switch n {
+		case 1:
+			size = 29 + uint(b[0])
+		case 2:
+			size = 285 + (uint(b[0])<<8 | uint(b[1]))
+		case 3:
+			size = 65821 + (uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+		}
+		offset += n
+	}
+
+	switch typ {
+	case 7: // map
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decode(offset, base)
+			if err != nil {
+				return nil, 0, err
+			}
+			v, next, err := d.decode(next, base)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, _ := k.(string)
+			m[key] = v
+			offset = next
+		}
+		return m, offset, nil
+	case 11: // array
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := d.decode(offset, base)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			offset = next
+		}
+		return a, offset, nil
+	case 14: // boolean, the value is in the size
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errors.New("value out of range")
+	}
+	b := d.buf[offset : offset+size]
+	offset += size
+	switch typ {
+	case 2: // string
+		return string(b), offset, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errors.New("bad double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errors.New("bad float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case 5, 6, 9: // uint16, uint32, uint64
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		return u, offset, nil
+	case 8: // int32
+		var u uint32
+		for _, c := range b {
+			u = u<<8 | uint32(c)
+		}
+		return int64(int32(u)), offset, nil
+	}
+	// bytes, uint128 and anything else is returned as is.
+	return b, offset, nil
+}
+
+func toUint(v interface{}) uint {
+	u, _ := v.(uint64)
+	return uint(u)
+}
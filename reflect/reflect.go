@@ -22,6 +22,14 @@
 //	;; ADDITIONAL SECTION:
 //	whoami.miek.nl.		0	IN	TXT	"Port: 56195 (udp)"
 //
+// The domain is set with -domain, which can be given multiple times; the
+// default is whoami.miek.nl. Querying tc.<domain> returns a truncated reply,
+// more names that misbehave (always TC, half a message, a delay, FORMERR or a
+// random rcode) are added with -test name=behavior.
+// Any name below the domain is answered as well, a random label in front of
+// it (e.g. 8f3a.whoami.miek.nl) gets past the cache of the resolver. The TTL
+// of the records is set with -ttl.
+//
 // Similar services: whoami.ultradns.net, whoami.akamai.net. Also (but it
 // is not their normal goal): rs.dns-oarc.net, porttest.dns-oarc.net,
 // amiopen.openresolvers.org.
@@ -32,6 +40,7 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
@@ -46,93 +55,244 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/miekg/exdns/dnstap"
+	"github.com/miekg/exdns/ratelimit"
 )
 
 var (
 	cpuprofile  = flag.String("cpuprofile", "", "write cpu profile to file")
 	printf      = flag.Bool("print", false, "print replies")
 	compress    = flag.Bool("compress", false, "compress replies")
-	tsig        = flag.String("tsig", "", "use SHA256 hmac tsig: keyname:base64")
+	tsig        = tsigKeys{}
 	soreuseport = flag.Int("soreuseport", 0, "use SO_REUSE_PORT")
 	cpu         = flag.Int("cpu", 0, "number of cpu to use")
+	nsid        = flag.String("nsid", "", "identity to answer NSID requests with")
+	logf        = flag.String("log", "", "log every query to stdout, the format must be json")
+	dnstapf     = flag.String("dnstap", "", "log queries and replies as dnstap to this file or tcp:host:port")
+	rate        = flag.Int("rate", 0, "answer at most this many UDP queries per second per client, 0 is unlimited")
+	burst       = flag.Int("burst", 10, "number of queries a client may send at once with -rate")
+	drop        = flag.Bool("drop", false, "drop the queries of refused clients instead of replying REFUSED")
+	allow       prefixes
+	geodb       files
+	domainf     domains
+	ttl         = flag.Uint("ttl", 0, "TTL of the records in the replies")
+	only4       = flag.Bool("4", false, "listen on IPv4")
+	only6       = flag.Bool("6", false, "listen on IPv6, with -4 as well there are separate sockets for both")
 )
 
-const dom = "whoami.miek.nl."
+// defaultDomain is used when no -domain is given.
+const defaultDomain = "whoami.miek.nl."
+
+// domains is the list of names given with -domain, it can be repeated.
+type domains []string
+
+func (d *domains) String() string { return strings.Join(*d, ",") }
 
-func handleReflect(w dns.ResponseWriter, r *dns.Msg) {
+func (d *domains) Set(s string) error {
+	if _, ok := dns.IsDomainName(s); !ok {
+		return fmt.Errorf("invalid domain name: %q", s)
+	}
+	*d = append(*d, strings.ToLower(dns.Fqdn(s)))
+	return nil
+}
+
+// soaFor returns the SOA record that is used in AXFR responses for dom.
+func soaFor(dom string) dns.RR {
+	if dom == defaultDomain {
+		soa, _ := dns.NewRR(`whoami.miek.nl. 0 IN SOA linode.atoom.net. miek.miek.nl. 2009032802 21600 7200 604800 3600`)
+		return soa
+	}
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: dom, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 0},
+		Ns:      dom,
+		Mbox:    "hostmaster." + dom,
+		Serial:  2009032802,
+		Refresh: 21600,
+		Retry:   7200,
+		Expire:  604800,
+		Minttl:  3600,
+	}
+}
+
+// testName returns the label of name directly below dom, this selects the
+// special names. Labels in front of it are ignored, so a client can prefix a
+// random label to get past the cache of its resolver.
+func testName(name, dom string) string {
+	labels := dns.SplitDomainName(strings.TrimSuffix(name, dom))
+	if len(labels) == 0 {
+		return ""
+	}
+	return labels[len(labels)-1]
+}
+
+// handleReflect answers with the address of the client for the names in the
+// domain dom, and for every name below it. Some names below dom are special,
+// they may be prefixed with more labels:
+//
+//	edns.<domain>       TXT records describing the EDNS0 OPT record of the query
+//	cookie.<domain>     a TXT record telling if the query had a valid server cookie
+//	tcp.<domain>        only answered over TCP, over UDP the reply is truncated
+//	udp.<domain>        only answered over UDP, over TCP the reply is REFUSED
+//	size-NNNN.<domain>  the reply is padded to NNNN bytes
+//	whoami4.<domain>    only answered over IPv4, over IPv6 the reply is REFUSED
+//	whoami6.<domain>    only answered over IPv6, over IPv4 the reply is REFUSED
+func handleReflect(w dns.ResponseWriter, r *dns.Msg, dom string) {
 	var (
-		v4  bool
-		rr  dns.RR
-		str string
-		a   net.IP
+		v4    bool
+		rr    dns.RR
+		str   string
+		a     net.IP
+		proto string
 	)
 	m := new(dns.Msg)
 	m.SetReply(r)
 	m.Compress = *compress
 	if ip, ok := w.RemoteAddr().(*net.UDPAddr); ok {
-		str = "Port: " + strconv.Itoa(ip.Port) + " (udp)"
+		proto = "udp"
 		a = ip.IP
 		v4 = a.To4() != nil
+		str = "Port: " + strconv.Itoa(ip.Port) + " (udp)"
 	}
 	if ip, ok := w.RemoteAddr().(*net.TCPAddr); ok {
-		str = "Port: " + strconv.Itoa(ip.Port) + " (tcp)"
+		proto = "tcp"
 		a = ip.IP
 		v4 = a.To4() != nil
+		str = "Port: " + strconv.Itoa(ip.Port) + " (tcp)"
+	}
+	// A request with a TSIG that doesn't verify, or that uses another
+	// algorithm than its key was given with, is not answered.
+	if t := r.IsTsig(); t != nil {
+		if err := tsig.verify(w, t); err != nil {
+			log.Printf("TSIG from %s: %s", w.RemoteAddr(), err)
+			m.Rcode = dns.RcodeNotAuth
+			w.WriteMsg(m)
+			return
+		}
 	}
+	owner := r.Question[0].Name
+	test := testName(strings.ToLower(owner), dom)
 
 	if v4 {
 		rr = &dns.A{
-			Hdr: dns.RR_Header{Name: dom, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 0},
+			Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: uint32(*ttl)},
 			A:   a.To4(),
 		}
 	} else {
 		rr = &dns.AAAA{
-			Hdr:  dns.RR_Header{Name: dom, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 0},
+			Hdr:  dns.RR_Header{Name: owner, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: uint32(*ttl)},
 			AAAA: a,
 		}
 	}
 
 	t := &dns.TXT{
-		Hdr: dns.RR_Header{Name: dom, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+		Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(*ttl)},
 		Txt: []string{str},
 	}
 
-	switch r.Question[0].Qtype {
-	case dns.TypeTXT:
+	// Extra TXT records with information about the client. With an EDNS0
+	// Client Subnet option the forwarded subnet is reported and the option is
+	// echoed with the scope set to it.
+	var info []string
+	cookie := "cookie: none"
+	if opt := r.IsEdns0(); opt != nil {
+		o := m.SetEdns0(opt.UDPSize(), opt.Do()).IsEdns0()
+		for _, e := range opt.Option {
+			if sn, ok := e.(*dns.EDNS0_SUBNET); ok {
+				info = append(info, fmt.Sprintf("ECS: %s/%d", sn.Address, sn.SourceNetmask))
+				reply := *sn
+				reply.SourceScope = sn.SourceNetmask
+				o.Option = append(o.Option, &reply)
+			}
+			if _, ok := e.(*dns.EDNS0_NSID); ok && *nsid != "" {
+				o.Option = append(o.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: hex.EncodeToString([]byte(*nsid))})
+			}
+			if c, ok := e.(*dns.EDNS0_COOKIE); ok {
+				now := time.Now()
+				client, valid, err := checkCookie(c.Cookie, a, now)
+				if err != nil {
+					m.Rcode = dns.RcodeFormatError
+					w.WriteMsg(m)
+					return
+				}
+				cookie = "cookie: client cookie only"
+				if valid {
+					cookie = "cookie: valid server cookie"
+				} else if len(c.Cookie) > 16 {
+					cookie = "cookie: invalid or expired server cookie"
+				}
+				o.Option = append(o.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: newCookie(client, a, now)})
+			}
+		}
+	}
+
+	info = append(info, family(a))
+	info = append(info, geoInfo(a)...)
+	var extra []dns.RR
+	for _, txt := range info {
+		extra = append(extra, &dns.TXT{
+			Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(*ttl)},
+			Txt: []string{txt},
+		})
+	}
+
+	switch qtype := r.Question[0].Qtype; {
+	case test == "edns":
+		m.Answer = append(m.Answer, ednsInfo(owner, r.IsEdns0())...)
+	case test == "cookie":
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(*ttl)},
+			Txt: []string{cookie},
+		})
+	case test == "tcp" && proto == "udp":
+		m.Truncated = true
+	case test == "udp" && proto == "tcp":
+		m.Rcode = dns.RcodeRefused
+	case test == "whoami4" && !v4, test == "whoami6" && v4:
+		m.Rcode = dns.RcodeRefused
+	case qtype == dns.TypeTXT:
 		m.Answer = append(m.Answer, t)
+		m.Answer = append(m.Answer, extra...)
 		m.Extra = append(m.Extra, rr)
 	default:
 		fallthrough
-	case dns.TypeAAAA, dns.TypeA:
+	case qtype == dns.TypeAAAA, qtype == dns.TypeA:
 		m.Answer = append(m.Answer, rr)
 		m.Extra = append(m.Extra, t)
-	case dns.TypeAXFR, dns.TypeIXFR:
+		m.Extra = append(m.Extra, extra...)
+	case qtype == dns.TypeAXFR, qtype == dns.TypeIXFR:
 		c := make(chan *dns.Envelope)
 		tr := new(dns.Transfer)
-		defer close(c)
-		if err := tr.Out(w, r, c); err != nil {
-			return
-		}
-		soa, _ := dns.NewRR(`whoami.miek.nl. 0 IN SOA linode.atoom.net. miek.miek.nl. 2009032802 21600 7200 604800 3600`)
+		done := make(chan struct{})
+		go func() {
+			tr.Out(w, r, c)
+			close(done)
+		}()
+		soa := soaFor(dom)
 		c <- &dns.Envelope{RR: []dns.RR{soa, t, rr, soa}}
+		close(c)
+		<-done
 		w.Hijack()
 		// w.Close() // Client closes connection
 		return
 	}
 
-	if r.IsTsig() != nil {
-		if w.TsigStatus() == nil {
-			m.SetTsig(r.Extra[len(r.Extra)-1].(*dns.TSIG).Hdr.Name, dns.HmacSHA256, 300, time.Now().Unix())
-		} else {
-			println("Status", w.TsigStatus().Error())
+	// size-NNNN.<domain> pads the reply to NNNN bytes.
+	if strings.HasPrefix(test, "size-") {
+		if size, err := strconv.Atoi(strings.TrimPrefix(test, "size-")); err == nil {
+			pad(m, size, proto, r.IsEdns0())
 		}
 	}
+	// The test names given with -test misbehave.
+	if t, ok := tests[test]; ok {
+		t.misbehave(m)
+	}
+	if t := r.IsTsig(); t != nil {
+		m.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+	}
 	if *printf {
 		fmt.Printf("%v\n", m.String())
 	}
-	// set TC when question is tc.miek.nl.
-	if m.Question[0].Name == "tc.miek.nl." {
-		m.Truncated = true
+	if tests[test].kind == "half" {
 		// send half a message
 		buf, _ := m.Pack()
 		w.Write(buf[:len(buf)/2])
@@ -141,31 +301,123 @@ func handleReflect(w dns.ResponseWriter, r *dns.Msg) {
 	w.WriteMsg(m)
 }
 
-func serve(net, name, secret string, soreuseport bool) {
-	switch name {
-	case "":
-		server := &dns.Server{Addr: "[::]:8053", Net: net, TsigSecret: nil, ReusePort: soreuseport}
-		if err := server.ListenAndServe(); err != nil {
-			fmt.Printf("Failed to setup the "+net+" server: %s\n", err.Error())
+// pad pads m to size bytes, with an EDNS0 padding option when the reply has an
+// OPT record and with a TXT record in the additional section when it hasn't.
+// Over UDP a size larger than the buffer size of the client (opt) sets TC
+// instead, so the client retries over TCP. A TSIG is added after this, it is
+// not counted.
+func pad(m *dns.Msg, size int, proto string, opt *dns.OPT) {
+	size = min(size, dns.MaxMsgSize)
+	if proto == "udp" {
+		bufsize := dns.MinMsgSize
+		if opt != nil {
+			bufsize = max(int(opt.UDPSize()), dns.MinMsgSize)
+		}
+		if size > bufsize {
+			m.Truncated = true
+			return
+		}
+	}
+	if o := m.IsEdns0(); o != nil {
+		if n := size - m.Len() - 4; n >= 0 { // 4 bytes for the option code and length
+			o.Option = append(o.Option, &dns.EDNS0_PADDING{Padding: make([]byte, n)})
+		}
+		return
+	}
 
+	filler := &dns.TXT{
+		Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(*ttl)},
+		Txt: []string{""},
+	}
+	m.Extra = append(m.Extra, filler)
+	n := size - m.Len()
+	if n < 0 {
+		m.Extra = m.Extra[:len(m.Extra)-1]
+		return
+	}
+	// The empty string is already there, fill it and add more strings, each
+	// costs a length byte.
+	l := min(n, 255)
+	filler.Txt[0] = strings.Repeat("x", l)
+	for n -= l; n > 0; n -= l + 1 {
+		l = min(n-1, 255)
+		filler.Txt = append(filler.Txt, strings.Repeat("x", l))
+	}
+}
+
+// ednsInfo returns TXT records with owner that describe the OPT record opt:
+// the buffer size, version, DO bit and the options.
+func ednsInfo(owner string, opt *dns.OPT) []dns.RR {
+	var txt []string
+	if opt == nil {
+		txt = append(txt, "no EDNS0")
+	} else {
+		txt = append(txt,
+			"bufsize: "+strconv.Itoa(int(opt.UDPSize())),
+			"version: "+strconv.Itoa(int(opt.Version())),
+			"do: "+strconv.FormatBool(opt.Do()),
+		)
+		for _, e := range opt.Option {
+			switch o := e.(type) {
+			case *dns.EDNS0_COOKIE:
+				if len(o.Cookie) > 16 {
+					txt = append(txt, "cookie: client "+o.Cookie[:16]+", server "+o.Cookie[16:])
+				} else {
+					txt = append(txt, "cookie: client "+o.Cookie)
+				}
+			case *dns.EDNS0_SUBNET:
+				txt = append(txt, fmt.Sprintf("subnet: %s/%d", o.Address, o.SourceNetmask))
+			case *dns.EDNS0_PADDING:
+				txt = append(txt, fmt.Sprintf("padding: %d bytes", len(o.Padding)))
+			case *dns.EDNS0_NSID:
+				txt = append(txt, "nsid: requested")
+			default:
+				txt = append(txt, fmt.Sprintf("option %d: %s", e.Option(), e.String()))
+			}
 		}
-	default:
-		server := &dns.Server{Addr: ":8053", Net: net, TsigSecret: map[string]string{name: secret}, ReusePort: soreuseport}
-		if err := server.ListenAndServe(); err != nil {
-			fmt.Printf("Failed to setup the "+net+" server: %s\n", err.Error())
+	}
+	rrs := make([]dns.RR, len(txt))
+	for i := range txt {
+		rrs[i] = &dns.TXT{
+			Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(*ttl)},
+			Txt: []string{txt[i]},
 		}
 	}
+	return rrs
+}
+
+// family returns the address family the query from a came in over. An IPv4
+// address on a dual stack socket is v4-mapped, it is 16 bytes long.
+func family(a net.IP) string {
+	switch {
+	case a.To4() == nil:
+		return "Family: IPv6"
+	case len(a) == net.IPv6len:
+		return "Family: IPv4 (v4-mapped IPv6)"
+	}
+	return "Family: IPv4"
+}
+
+// serve listens on net, which is tcp or udp with an optional 4 or 6 suffix.
+func serve(net string, soreuseport bool) {
+	server := &dns.Server{Addr: ":8053", Net: net, TsigSecret: tsig.secrets(), ReusePort: soreuseport}
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Printf("Failed to setup the "+net+" server: %s\n", err.Error())
+	}
 }
 
 func main() {
-	var name, secret string
+	flag.Var(tsig, "tsig", "accept and sign with this tsig key: [hmac-sha1|hmac-sha256|hmac-sha512:]keyname:base64, can be repeated (default hmac-sha256)")
+	flag.Var(&domainf, "domain", "answer for this domain, can be repeated (default "+defaultDomain+")")
+	flag.Var(&allow, "allow", "only answer clients in this prefix, can be repeated")
+	flag.Var(tests, "test", "add a test name that misbehaves: name=tc|half|delay[:2s]|formerr|rcode[:NAME], can be repeated")
+	flag.Var(&geodb, "geodb", "report the ASN and country of the client from this MaxMind or ipinfo mmdb file, can be repeated")
 	flag.Usage = func() {
 		flag.PrintDefaults()
 	}
 	flag.Parse()
-	if *tsig != "" {
-		a := strings.SplitN(*tsig, ":", 2)
-		name, secret = dns.Fqdn(a[0]), a[1] // fqdn the name, which everybody forgets...
+	if len(domainf) == 0 {
+		domainf = domains{defaultDomain}
 	}
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
@@ -179,17 +431,52 @@ func main() {
 	if *cpu != 0 {
 		runtime.GOMAXPROCS(*cpu)
 	}
-	dns.HandleFunc("miek.nl.", handleReflect)
-	if *soreuseport > 0 {
-		for i := 0; i < *soreuseport; i++ {
-			go serve("tcp", name, secret, true)
-			go serve("udp", name, secret, true)
+	for _, f := range geodb {
+		db, err := openMMDB(f)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %s", f, err)
+		}
+		geoDBs = append(geoDBs, db)
+	}
+	if *logf != "" && *logf != "json" {
+		log.Fatalf("Unknown log format %q", *logf)
+	}
+	if *dnstapf != "" {
+		var err error
+		if tap, err = dnstap.Open(*dnstapf, "reflect", 2*time.Second); err != nil {
+			log.Fatalf("Failed to open dnstap output: %s", err)
+		}
+		defer tap.Close()
+	}
+	// The limit is per source address, shared by all domains.
+	limit := ratelimit.New(*rate, *burst, 0)
+	limit.Prefix4, limit.Prefix6 = 32, 128
+	for _, dom := range domainf {
+		dom := dom
+		dns.HandleFunc(dom, logged(guarded(limit, func(w dns.ResponseWriter, r *dns.Msg) { handleReflect(w, r, dom) })))
+	}
+	// Without -4 and -6 a single dual stack socket is used.
+	families := []string{""}
+	switch {
+	case *only4 && *only6:
+		families = []string{"4", "6"}
+	case *only4:
+		families = []string{"4"}
+	case *only6:
+		families = []string{"6"}
+	}
+	for _, f := range families {
+		if *soreuseport > 0 {
+			for i := 0; i < *soreuseport; i++ {
+				go serve("tcp"+f, true)
+				go serve("udp"+f, true)
+			}
+		} else {
+			go serve("tcp"+f, false)
+			go serve("udp"+f, false)
 		}
-	} else {
-		go serve("tcp", name, secret, false)
-		go serve("udp", name, secret, false)
 	}
-	sig := make(chan os.Signal)
+	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	s := <-sig
 	fmt.Printf("Signal (%s) received, stopping\n", s)
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// tsigAlgorithms are the algorithms that can be used with -tsig.
+var tsigAlgorithms = map[string]string{
+	"sha1":   dns.HmacSHA1,
+	"sha224": dns.HmacSHA224,
+	"sha256": dns.HmacSHA256,
+	"sha384": dns.HmacSHA384,
+	"sha512": dns.HmacSHA512,
+}
+
+// tsigKey is a key given with -tsig.
+type tsigKey struct {
+	algorithm string
+	secret    string
+}
+
+// tsigKeys are the keys given with -tsig, indexed by the key name. The flag
+// can be repeated.
+type tsigKeys map[string]tsigKey
+
+func (k tsigKeys) String() string {
+	s := make([]string, 0, len(k))
+	for name, key := range k {
+		s = append(s, strings.TrimSuffix(key.algorithm, ".")+":"+name)
+	}
+	return strings.Join(s, ",")
+}
+
+// Set parses [algorithm:]name:base64, the algorithm defaults to hmac-sha256.
+func (k tsigKeys) Set(s string) error {
+	a := strings.Split(s, ":")
+	algo := dns.HmacSHA256
+	switch len(a) {
+	case 2:
+	case 3:
+		var ok bool
+		if algo, ok = tsigAlgorithms[strings.TrimPrefix(strings.ToLower(a[0]), "hmac-")]; !ok {
+			return fmt.Errorf("unknown tsig algorithm: %q", a[0])
+		}
+		a = a[1:]
+	default:
+		return fmt.Errorf("invalid tsig key: %q", s)
+	}
+	k[dns.CanonicalName(a[0])] = tsigKey{algorithm: algo, secret: a[1]} // fqdn the name, which everybody forgets...
+	return nil
+}
+
+// secrets returns the keys in the form dns.Server wants them.
+func (k tsigKeys) secrets() map[string]string {
+	if len(k) == 0 {
+		return nil
+	}
+	s := make(map[string]string, len(k))
+	for name, key := range k {
+		s[name] = key.secret
+	}
+	return s
+}
+
+// verify checks the TSIG t of a request, the MAC must be correct and the
+// algorithm must be the one the key was given with.
+func (k tsigKeys) verify(w dns.ResponseWriter, t *dns.TSIG) error {
+	if err := w.TsigStatus(); err != nil {
+		return err
+	}
+	key := k[dns.CanonicalName(t.Hdr.Name)]
+	if !strings.EqualFold(key.algorithm, t.Algorithm) {
+		return fmt.Errorf("key %s uses %s, not %s", t.Hdr.Name, key.algorithm, t.Algorithm)
+	}
+	return nil
+}